@@ -0,0 +1,118 @@
+package gincache
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// MethodPurge is request method to purge cached responses
+	MethodPurge = "PURGE"
+	// MethodBan is request method to ban cached responses, which keys match regular expression
+	MethodBan = "BAN"
+	// BanHeader is request header with regular expression, that keys of responses to be banned match
+	BanHeader = "X-Ban-Regexp"
+	// PurgeTagsHeader is request header with space separated tags of responses to be purged
+	PurgeTagsHeader = "Surrogate-Key"
+)
+
+// AdminRoutes registers Varnish-like handlers on router, that allow to invalidate responses cached by
+// caching backend provided. Only requests approved by authorize predicate (see RequireHeader) are
+// processed, others are rejected with 403 Forbidden, and nil predicate rejects all of them.
+// Routes are
+//
+//   - `PURGE /posts/1` purges response with key `/posts/1`, that is extracted by CacheByPath
+//   - `PURGE /posts/*` purges responses, which keys start with `/posts/`
+//   - `PURGE /*` flushes whole cache, including responses cached in namespaces or with keys, that do not start with `/`
+//   - `PURGE /` with `Surrogate-Key: post:1 post:2` header purges responses tagged by `post:1` or `post:2`
+//   - `BAN /` with `X-Ban-Regexp: ^/posts/[0-9]+$` header purges responses, which keys match regular expression
+//
+// Keys are path relative to router, and query string, if it is present. Response is JSON object with number
+// of responses removed, like `{"removed":2}`, or with error. If caching backend does not implement capability
+// required, PrefixDeleter, TagInvalidator or RegexpDeleter, request is answered with 501 Not Implemented.
+// Keys of responses cached in namespace (see WithNamespace) include its generation, so it is easier to
+// invalidate them by Generations.BumpGeneration.
+func AdminRoutes(router gin.IRouter, cache Cache, authorize func(c *gin.Context) bool) {
+	admin := adminHandler{cache: cache, authorize: authorize}
+	router.Handle(MethodPurge, "/*key", admin.purge)
+	router.Handle(MethodBan, "/*key", admin.ban)
+}
+
+// flushKey is key of PURGE request, that flushes whole cache
+const flushKey = "/*"
+
+type adminHandler struct {
+	cache     Cache
+	authorize func(c *gin.Context) bool
+}
+
+func (a *adminHandler) authorized(c *gin.Context) bool {
+	if a.authorize == nil || !a.authorize(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return false
+	}
+	return true
+}
+
+func (a *adminHandler) purge(c *gin.Context) {
+	if !a.authorized(c) {
+		return
+	}
+	var invalidation Invalidation
+	key := c.Param("key")
+	if c.Request.URL.RawQuery != "" {
+		key += "?" + c.Request.URL.RawQuery
+	}
+	tags := strings.Fields(strings.Join(c.Request.Header.Values(PurgeTagsHeader), " "))
+	switch {
+	case len(tags) > 0:
+		invalidation.Tags = tags
+	case key == flushKey:
+		invalidation.Prefixes = []string{""}
+	case strings.HasSuffix(key, "*"):
+		invalidation.Prefixes = []string{strings.TrimSuffix(key, "*")}
+	default:
+		invalidation.Keys = []string{key}
+	}
+	removed, err := Invalidate(c.Request.Context(), a.cache, invalidation)
+	respondInvalidation(c, removed, err)
+}
+
+func (a *adminHandler) ban(c *gin.Context) {
+	if !a.authorized(c) {
+		return
+	}
+	pattern := c.GetHeader(BanHeader)
+	if pattern == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": BanHeader + " header is missing"})
+		return
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	deleter, ok := a.cache.(RegexpDeleter)
+	if !ok {
+		respondInvalidation(c, 0, ErrUnsupported)
+		return
+	}
+	removed, err := deleter.DeleteRegexp(c.Request.Context(), re)
+	respondInvalidation(c, removed, err)
+}
+
+// respondInvalidation reports number of responses invalidated, or error occurred, as JSON object
+func respondInvalidation(c *gin.Context, removed int, err error) {
+	switch {
+	case errors.Is(err, ErrUnsupported):
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "removed": removed})
+	default:
+		c.JSON(http.StatusOK, gin.H{"removed": removed})
+	}
+}
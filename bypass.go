@@ -0,0 +1,30 @@
+package gincache
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// noCacheRequested reports, if client requested fresh response by Cache-Control: no-cache or Pragma: no-cache headers
+func noCacheRequested(c *gin.Context) bool {
+	_, noCache := parseCacheControl(strings.Join(c.Request.Header.Values("Cache-Control"), ","))["no-cache"]
+	if noCache {
+		return true
+	}
+	_, noCache = parseCacheControl(c.GetHeader("Pragma"))["no-cache"]
+	return noCache
+}
+
+// RequireHeader returns predicate, that reports, if request has header with secret value provided.
+// It can be used to authorize cache bypass or refresh.
+func RequireHeader(name, secret string) func(c *gin.Context) bool {
+	return func(c *gin.Context) bool {
+		value := c.GetHeader(name)
+		if value == "" || secret == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+	}
+}
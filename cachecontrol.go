@@ -0,0 +1,61 @@
+package gincache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCacheControl parses Cache-Control header value into map of lower case directives and their values
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return directives
+}
+
+// responseTTL applies Cache-Control and Expires headers set by handler to ttl of response. Responses
+// with no-store or private directives are not cached, s-maxage and max-age ones override ttl,
+// and so does Expires header, if it differs from ownExpires one set by middleware itself.
+func responseTTL(header http.Header, ttl time.Duration, now time.Time, ownExpires string) (time.Duration, bool) {
+	directives := parseCacheControl(strings.Join(header.Values("Cache-Control"), ","))
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	if noStore || private {
+		return 0, false
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		value, found := directives[name]
+		if !found {
+			continue
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	expires := header.Get("Expires")
+	if expires != "" && expires != ownExpires {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// RFC 7234 section 5.3 states, that invalid Expires means response is already expired
+			return 0, false
+		}
+		if !expiresAt.After(now) {
+			return 0, false
+		}
+		return expiresAt.Sub(now), true
+	}
+	return ttl, true
+}
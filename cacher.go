@@ -2,6 +2,9 @@ package gincache
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"regexp"
 	"time"
 )
 
@@ -11,8 +14,27 @@ type Data struct {
 	Body        []byte
 	Status      int
 	ContentType string
+	Headers     http.Header
+	ETag        string
+	Tags        []string
 	CreatedAt   time.Time
 	ExpiresAt   time.Time
+	// StaleUntil is time, until which expired response can be served, while it is being revalidated
+	StaleUntil time.Time
+	// StaleIfErrorUntil is time, until which expired response can be served, if handler fails
+	StaleIfErrorUntil time.Time
+}
+
+// RetainUntil returns time, until which cached response should be kept by backend
+func (d Data) RetainUntil() time.Time {
+	until := d.ExpiresAt
+	if d.StaleUntil.After(until) {
+		until = d.StaleUntil
+	}
+	if d.StaleIfErrorUntil.After(until) {
+		until = d.StaleIfErrorUntil
+	}
+	return until
 }
 
 // Cache is interface to be used with different caching backends. Currently, `memory` and `redis` backends are provided
@@ -21,3 +43,44 @@ type Cache interface {
 	Get(ctx context.Context, key string) (data Data, found bool, err error)
 	Delete(ctx context.Context, key string) (err error)
 }
+
+// Locker is optional capability of Cache, that allows only one of few processes sharing cache to render
+// response for key, while others wait for it to be saved
+type Locker interface {
+	// Lock tries to acquire lock for key for ttl provided, and returns token to release it
+	Lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error)
+	// Unlock releases lock for key, if it is still held with token provided
+	Unlock(ctx context.Context, key, token string) (err error)
+}
+
+// TagInvalidator is optional capability of Cache, that allows to delete all responses tagged by
+// any of tags provided, and returns number of responses deleted
+type TagInvalidator interface {
+	InvalidateTags(ctx context.Context, tags ...string) (removed int, err error)
+}
+
+// PrefixDeleter is optional capability of Cache, that allows to delete responses, which keys start with
+// prefix provided, or match glob pattern (see MatchGlob), and returns number of responses deleted
+type PrefixDeleter interface {
+	DeletePrefix(ctx context.Context, prefix string) (removed int, err error)
+	DeleteMatching(ctx context.Context, pattern string) (removed int, err error)
+}
+
+// RegexpDeleter is optional capability of Cache, that allows to delete responses, which keys match
+// regular expression provided, and returns number of responses deleted
+type RegexpDeleter interface {
+	DeleteRegexp(ctx context.Context, re *regexp.Regexp) (removed int, err error)
+}
+
+// Generations is optional capability of Cache, that stores generation counters of namespaces. Keys of responses
+// cached in namespace include its generation, so bumping it invalidates all of them at once, without
+// touching them, and they are purged, when their ttl is over.
+type Generations interface {
+	Generation(ctx context.Context, namespace string) (generation uint64, err error)
+	BumpGeneration(ctx context.Context, namespace string) (generation uint64, err error)
+}
+
+// NamespacedKey returns key, that is used to cache response in namespace of generation provided
+func NamespacedKey(namespace string, generation uint64, key string) string {
+	return fmt.Sprintf("%s:%d:%s", namespace, generation, key)
+}
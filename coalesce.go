@@ -0,0 +1,59 @@
+package gincache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flight is response being rendered by one request, while other requests for the same key wait for it
+type flight struct {
+	done chan struct{}
+	data Data
+	ok   bool
+}
+
+// wait waits for flight to complete, and returns rendered data, if it can be served to other clients
+func (f *flight) wait(ctx context.Context, timeout time.Duration) (data Data, ok bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return f.data, f.ok
+	case <-timer.C:
+		return
+	case <-ctx.Done():
+		return
+	}
+}
+
+// flightGroup coalesces concurrent requests for the same cache key, so only one of them renders response
+type flightGroup struct {
+	sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// join returns flight for key provided, and reports, if caller is leader, who should render response
+func (g *flightGroup) join(key string) (f *flight, leader bool) {
+	g.Lock()
+	defer g.Unlock()
+	f, found := g.flights[key]
+	if found {
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// land removes flight from group and wakes up requests waiting for it
+func (g *flightGroup) land(key string, f *flight) {
+	g.Lock()
+	delete(g.flights, key)
+	g.Unlock()
+	close(f.done)
+}
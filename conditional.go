@@ -0,0 +1,57 @@
+package gincache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpDate formats time as IMF-fixdate in GMT, as RFC 7231 section 7.1.1.1 requires
+func httpDate(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// ETag returns strong entity tag for response body provided
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports, if value of If-None-Match request header matches entity tag provided,
+// using weak comparison as RFC 7232 section 3.2 requires
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports, if client's copy of response is still valid according to conditional request headers.
+// If-Modified-Since is ignored, when If-None-Match is present, as RFC 7232 section 6 requires, or when
+// its date is later than current time, as RFC 7232 section 3.3 requires.
+func notModified(req *http.Request, etag string, lastModified time.Time) bool {
+	ifNoneMatch := req.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+	ifModifiedSince := req.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" || lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil || since.After(time.Now()) {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
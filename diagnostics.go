@@ -0,0 +1,60 @@
+package gincache
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	cacheHit    = "HIT"
+	cacheMiss   = "MISS"
+	cacheStale  = "STALE"
+	cacheBypass = "BYPASS"
+)
+
+// DiagnosticHeaders are names of response headers, that middleware uses to report how response was served.
+// Empty name disables corresponding header.
+type DiagnosticHeaders struct {
+	// Status header reports HIT, MISS, STALE or BYPASS
+	Status string
+	// Age header reports age of cached response in seconds
+	Age string
+	// Timing header reports caching backend lookup latency in Server-Timing format
+	Timing string
+}
+
+// DefaultDiagnosticHeaders are conventional names of diagnostic headers, that can be enabled by WithDiagnosticHeaders
+var DefaultDiagnosticHeaders = DiagnosticHeaders{
+	Status: "X-Cache",
+	Age:    "Age",
+	Timing: "Server-Timing",
+}
+
+// status sets header with cache status provided
+func (d *DiagnosticHeaders) status(c *gin.Context, status string) {
+	if d.Status != "" {
+		c.Header(d.Status, status)
+	}
+}
+
+// age sets header with age of cached response
+func (d *DiagnosticHeaders) age(c *gin.Context, createdAt time.Time) {
+	if d.Age == "" || createdAt.IsZero() {
+		return
+	}
+	age := time.Since(createdAt)
+	if age < 0 {
+		age = 0
+	}
+	c.Header(d.Age, strconv.FormatInt(int64(age/time.Second), 10))
+}
+
+// timing adds header entry with caching backend lookup latency
+func (d *DiagnosticHeaders) timing(c *gin.Context, lookup time.Duration) {
+	if d.Timing != "" {
+		c.Writer.Header().Add(d.Timing, fmt.Sprintf("cache;desc=\"lookup\";dur=%.3f", float64(lookup)/float64(time.Millisecond)))
+	}
+}
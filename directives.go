@@ -0,0 +1,74 @@
+package gincache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// directiveKey is type of gin context keys, that handlers use to control caching of their responses
+type directiveKey string
+
+const (
+	skipKey directiveKey = "skip"
+	ttlKey  directiveKey = "ttl"
+	tagsKey directiveKey = "tags"
+)
+
+// Skip makes middleware not to save response of current request in cache
+func Skip(c *gin.Context) {
+	c.Set(skipKey, true)
+}
+
+// SetTTL overrides ttl of response of current request. It cannot make response, that cannot be cached
+// due to its status code or Cache-Control header, cacheable. Zero or negative ttl means response is not cached.
+func SetTTL(c *gin.Context, ttl time.Duration) {
+	c.Set(ttlKey, ttl)
+}
+
+// AddTags adds tags to response of current request, so it can be invalidated by them later
+func AddTags(c *gin.Context, tags ...string) {
+	c.Set(tagsKey, append(c.GetStringSlice(tagsKey), tags...))
+}
+
+// DefaultShouldCache is default predicate, that allows saving response, only if handler has not
+// reported any errors by c.Error or c.AbortWithError
+func DefaultShouldCache(c *gin.Context) bool {
+	return len(c.Errors) == 0
+}
+
+// directives applies Skip and SetTTL calls made by handler to ttl of response
+func directives(c *gin.Context, ttl time.Duration) (time.Duration, bool) {
+	if c.GetBool(skipKey) {
+		return 0, false
+	}
+	value, found := c.Get(ttlKey)
+	if !found {
+		return ttl, true
+	}
+	override, ok := value.(time.Duration)
+	if !ok || override <= 0 {
+		return 0, false
+	}
+	return override, true
+}
+
+// tags returns tags added by AddTags and listed in Surrogate-Key response header, without duplicates
+func tags(c *gin.Context, header http.Header) []string {
+	all := append([]string(nil), c.GetStringSlice(tagsKey)...)
+	all = append(all, strings.Fields(strings.Join(header.Values("Surrogate-Key"), " "))...)
+	if len(all) == 0 {
+		return nil
+	}
+	unique := make([]string, 0, len(all))
+	seen := make(map[string]bool, len(all))
+	for _, tag := range all {
+		if !seen[tag] {
+			seen[tag] = true
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,39 @@
+package gincache
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Phase depicts stage of middleware execution where error occurred
+type Phase string
+
+const (
+	// PhaseExtract means error was returned by key extractor function
+	PhaseExtract Phase = "extract"
+	// PhaseGet means error was returned by Cache.Get
+	PhaseGet Phase = "get"
+	// PhaseSave means error was returned by Cache.Save
+	PhaseSave Phase = "save"
+	// PhaseLock means error was returned by Locker.Lock
+	PhaseLock Phase = "lock"
+	// PhaseUnlock means error was returned by Locker.Unlock
+	PhaseUnlock Phase = "unlock"
+	// PhaseGeneration means error was returned by Generations.Generation
+	PhaseGeneration Phase = "generation"
+	// PhaseInvalidate means error occurred while applying InvalidationRule
+	PhaseInvalidate Phase = "invalidate"
+	// PhaseHandler means handler panicked, and stale response was served instead
+	PhaseHandler Phase = "handler"
+)
+
+// ErrorHandler is called by middleware every time key extractor or caching backend returns error
+type ErrorHandler func(c *gin.Context, phase Phase, err error)
+
+// DefaultErrorHandler writes error to gin.DefaultErrorWriter
+func DefaultErrorHandler(c *gin.Context, phase Phase, err error) {
+	fmt.Fprintf(gin.DefaultErrorWriter, "[GIN-CACHE] %s : while performing %s for %s %s\n",
+		err, phase, c.Request.Method, c.Request.URL.Path,
+	)
+}
@@ -0,0 +1,73 @@
+package gincache
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MatchGlob reports, if key matches glob pattern using the same rules, as redis KEYS and SCAN commands do:
+// `*` matches any sequence of characters including `/`, `?` matches any single character, `[abc]`, `[^abc]`
+// and `[a-z]` match character classes, and `\` escapes special characters.
+func MatchGlob(pattern, key string) bool {
+	re, err := CompileGlob(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(key)
+}
+
+// CompileGlob converts glob pattern to regular expression, so it can be matched against many keys
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString(`(?s)^`)
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			expr.WriteString(`.*`)
+		case '?':
+			expr.WriteString(`.`)
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				// unterminated class is matched literally
+				expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+				continue
+			}
+			expr.WriteString(`[`)
+			class := runes[i+1 : end]
+			if len(class) > 0 && class[0] == '^' {
+				expr.WriteString(`^`)
+				class = class[1:]
+			}
+			for j := 0; j < len(class); j++ {
+				switch {
+				case class[j] == '\\' && j+1 < len(class):
+					j++
+					expr.WriteString(regexp.QuoteMeta(string(class[j])))
+				case class[j] == '-':
+					expr.WriteString(`-`)
+				default:
+					expr.WriteString(regexp.QuoteMeta(string(class[j])))
+				}
+			}
+			expr.WriteString(`]`)
+			i = end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	expr.WriteString(`$`)
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,90 @@
+package gincache
+
+import (
+	"net/http"
+)
+
+// excludedHeaders are never saved in cache - they are hop-by-hop ones, cookies and headers,
+// that are managed by middleware itself
+var excludedHeaders = map[string]bool{
+	"Set-Cookie":          true,
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Type":        true,
+	"Content-Length":      true,
+	"Date":                true,
+	"Last-Modified":       true,
+	"Expires":             true,
+	"Etag":                true,
+	"Age":                 true,
+	"Warning":             true,
+}
+
+type headerFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newHeaderSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for i := range names {
+		set[http.CanonicalHeaderKey(names[i])] = true
+	}
+	return set
+}
+
+// filter returns copy of response headers, that can be saved in cache. Headers, that were already set
+// before handler was executed, for example, by other middleware, are skipped, unless handler changed them,
+// because they can be different for every request, like X-Request-Id or Access-Control-Allow-Origin.
+func (f *headerFilter) filter(header, before http.Header) http.Header {
+	filtered := make(http.Header)
+	for name, values := range header {
+		name = http.CanonicalHeaderKey(name)
+		if excludedHeaders[name] || f.deny[name] {
+			continue
+		}
+		if len(f.allow) > 0 && !f.allow[name] {
+			continue
+		}
+		if equalValues(before[name], values) {
+			continue
+		}
+		filtered[name] = append([]string(nil), values...)
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+// exclude adds names provided to deny list
+func (f *headerFilter) exclude(names ...string) {
+	if f.deny == nil {
+		f.deny = make(map[string]bool)
+	}
+	for i := range names {
+		if names[i] != "" {
+			f.deny[http.CanonicalHeaderKey(names[i])] = true
+		}
+	}
+}
+
+// equalValues reports, if header values are the same
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
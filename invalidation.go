@@ -0,0 +1,128 @@
+package gincache
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrUnsupported is returned, when caching backend does not implement capability required for operation
+var ErrUnsupported = errors.New("operation is not supported by caching backend")
+
+// Invalidation is set of cache keys, key prefixes and tags of responses to be deleted from cache
+type Invalidation struct {
+	Keys     []string `json:"keys,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// Invalidate deletes responses with keys, keys starting with prefixes and tagged by tags provided from cache,
+// and returns number of responses deleted. Prefixes require Cache to implement PrefixDeleter, and tags require
+// it to implement TagInvalidator, otherwise ErrUnsupported is returned.
+func Invalidate(ctx context.Context, cache Cache, invalidation Invalidation) (removed int, err error) {
+	for _, key := range invalidation.Keys {
+		_, found, errG := cache.Get(ctx, key)
+		if errG != nil {
+			return removed, errG
+		}
+		err = cache.Delete(ctx, key)
+		if err != nil {
+			return
+		}
+		if found {
+			removed++
+		}
+	}
+	if len(invalidation.Prefixes) > 0 {
+		deleter, ok := cache.(PrefixDeleter)
+		if !ok {
+			return removed, ErrUnsupported
+		}
+		for _, prefix := range invalidation.Prefixes {
+			deleted, errD := deleter.DeletePrefix(ctx, prefix)
+			removed += deleted
+			if errD != nil {
+				return removed, errD
+			}
+		}
+	}
+	if len(invalidation.Tags) > 0 {
+		invalidator, ok := cache.(TagInvalidator)
+		if !ok {
+			return removed, ErrUnsupported
+		}
+		deleted, errI := invalidator.InvalidateTags(ctx, invalidation.Tags...)
+		removed += deleted
+		if errI != nil {
+			return removed, errI
+		}
+	}
+	return
+}
+
+// InvalidationRule maps successful mutation request to responses to be invalidated. Keys, prefixes and tags
+// can contain route parameters, like `:id`, that are replaced by their values, for example, rule
+//
+//	InvalidationRule{
+//		Method:       http.MethodDelete,
+//		Route:        "/posts/:id",
+//		Invalidation: Invalidation{Keys: []string{"/posts", "/posts/:id"}, Tags: []string{"post::id"}},
+//	}
+//
+// invalidates responses cached with keys `/posts`, `/posts/1` and tagged by `post:1` after `DELETE /posts/1`
+// request is processed.
+type InvalidationRule struct {
+	// Method is request method, empty one matches any method
+	Method string
+	// Route is gin route pattern, as c.FullPath() returns it
+	Route string
+	Invalidation
+}
+
+var routeParameter = regexp.MustCompile(`:[A-Za-z0-9_]+`)
+
+// supported reports, if caching backend implements capabilities required to apply rule
+func (r *InvalidationRule) supported(cache Cache) bool {
+	if _, ok := cache.(PrefixDeleter); len(r.Prefixes) > 0 && !ok {
+		return false
+	}
+	if _, ok := cache.(TagInvalidator); len(r.Tags) > 0 && !ok {
+		return false
+	}
+	return true
+}
+
+// matches reports, if rule should be applied to request
+func (r *InvalidationRule) matches(c *gin.Context) bool {
+	if r.Method != "" && r.Method != c.Request.Method {
+		return false
+	}
+	return r.Route == c.FullPath()
+}
+
+// expand returns invalidation with route parameters replaced by their values
+func (r *InvalidationRule) expand(params gin.Params) Invalidation {
+	replace := func(values []string) []string {
+		if len(values) == 0 {
+			return nil
+		}
+		expanded := make([]string, len(values))
+		for i := range values {
+			expanded[i] = routeParameter.ReplaceAllStringFunc(values[i], func(name string) string {
+				value, found := params.Get(name[1:])
+				if !found {
+					return name
+				}
+				return value
+			})
+		}
+		return expanded
+	}
+	return Invalidation{
+		Keys:     replace(r.Keys),
+		Prefixes: replace(r.Prefixes),
+		Tags:     replace(r.Tags),
+	}
+}
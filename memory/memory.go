@@ -2,6 +2,8 @@ package memory
 
 import (
 	"context"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,7 +13,10 @@ import (
 // Cache is memory cache storage engine
 type Cache struct {
 	sync.RWMutex
-	items              map[string]parent.Data
+	items map[string]parent.Data
+	// tags is inverted index of keys tagged by every tag
+	tags               map[string]map[string]struct{}
+	generations        map[string]uint64
 	expirationInterval time.Duration
 }
 
@@ -19,6 +24,8 @@ type Cache struct {
 func New(expirationInterval time.Duration) *Cache {
 	cache := Cache{
 		items:              make(map[string]parent.Data),
+		tags:               make(map[string]map[string]struct{}),
+		generations:        make(map[string]uint64),
 		expirationInterval: expirationInterval,
 	}
 	if expirationInterval > 0 {
@@ -35,7 +42,18 @@ func (m *Cache) Save(ctx context.Context, key string, data parent.Data) (err err
 		data.CreatedAt = time.Now()
 	}
 	data.Key = key
+	data.Headers = data.Headers.Clone()
+	data.Tags = append([]string(nil), data.Tags...)
+	m.unindex(key)
 	m.items[key] = data
+	for _, tag := range data.Tags {
+		keys, found := m.tags[tag]
+		if !found {
+			keys = make(map[string]struct{})
+			m.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
 	return nil
 }
 
@@ -54,21 +72,112 @@ func (m *Cache) Get(ctx context.Context, key string) (data parent.Data, found bo
 func (m *Cache) Delete(ctx context.Context, key string) (err error) {
 	m.Lock()
 	defer m.Unlock()
+	m.delete(key)
+	return
+}
+
+// InvalidateTags deletes all items tagged by any of tags provided
+func (m *Cache) InvalidateTags(ctx context.Context, tags ...string) (removed int, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, tag := range tags {
+		for key := range m.tags[tag] {
+			if m.delete(key) {
+				removed++
+			}
+		}
+	}
+	return
+}
+
+// DeletePrefix deletes all items, which keys start with prefix provided
+func (m *Cache) DeletePrefix(ctx context.Context, prefix string) (removed int, err error) {
+	return m.deleteFunc(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}), nil
+}
+
+// DeleteMatching deletes all items, which keys match glob pattern provided
+func (m *Cache) DeleteMatching(ctx context.Context, pattern string) (removed int, err error) {
+	re, err := parent.CompileGlob(pattern)
+	if err != nil {
+		return
+	}
+	return m.deleteFunc(re.MatchString), nil
+}
+
+// DeleteRegexp deletes all items, which keys match regular expression provided
+func (m *Cache) DeleteRegexp(ctx context.Context, re *regexp.Regexp) (removed int, err error) {
+	return m.deleteFunc(re.MatchString), nil
+}
+
+// deleteFunc deletes all items, which keys satisfy predicate provided
+func (m *Cache) deleteFunc(match func(key string) bool) (removed int) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.items {
+		if match(key) && m.delete(key) {
+			removed++
+		}
+	}
+	return
+}
+
+// Generation returns current generation of namespace
+func (m *Cache) Generation(ctx context.Context, namespace string) (generation uint64, err error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.generations[namespace], nil
+}
+
+// BumpGeneration increments generation of namespace, so all items cached in it are invalidated
+func (m *Cache) BumpGeneration(ctx context.Context, namespace string) (generation uint64, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.generations[namespace]++
+	return m.generations[namespace], nil
+}
+
+// delete deletes item and its tags from index, it should be called with lock held
+func (m *Cache) delete(key string) bool {
 	_, found := m.items[key]
 	if found {
+		m.unindex(key)
 		delete(m.items, key)
 	}
-	return
+	return found
+}
+
+// unindex removes key from tags index, it should be called with lock held
+func (m *Cache) unindex(key string) {
+	for _, tag := range m.items[key].Tags {
+		keys := m.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(m.tags, tag)
+		}
+	}
 }
 
 func (m *Cache) startGC() {
 	tc := time.NewTicker(m.expirationInterval)
-	ctx := context.Background()
 	for t := range tc.C {
+		expired := make([]string, 0)
+		m.RLock()
 		for key, item := range m.items {
-			if item.ExpiresAt.Before(t) {
-				m.Delete(ctx, key)
+			if item.RetainUntil().Before(t) {
+				expired = append(expired, key)
+			}
+		}
+		m.RUnlock()
+		m.Lock()
+		for _, key := range expired {
+			// item can be saved again, while lock was released
+			item, found := m.items[key]
+			if found && item.RetainUntil().Before(t) {
+				m.delete(key)
 			}
 		}
+		m.Unlock()
 	}
 }
@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"net/http"
+	"regexp"
 	"testing"
 	"time"
 
@@ -28,6 +29,8 @@ func TestMemoryCache_Save(t *testing.T) {
 		Body:        []byte("this is body of a key"),
 		Status:      http.StatusTeapot,
 		ContentType: "text/plain",
+		Headers:     http.Header{"X-Powered-By": []string{"gin"}},
+		Tags:        []string{"letters"},
 		CreatedAt:   time.Now(),
 		ExpiresAt:   time.Now().Add(time.Second),
 	})
@@ -66,6 +69,12 @@ func TestMemoryCache_Get(t *testing.T) {
 	if string(hit.Body) != "this is body of a key" {
 		t.Error("wrongly saved?")
 	}
+	if hit.Headers.Get("X-Powered-By") != "gin" {
+		t.Error("headers wrongly saved?")
+	}
+	if len(hit.Tags) != 1 || hit.Tags[0] != "letters" {
+		t.Error("tags wrongly saved?")
+	}
 }
 
 func TestMemoryCache_Delete(t *testing.T) {
@@ -121,3 +130,139 @@ func TestExpires(t *testing.T) {
 		t.Error("deleted key is found?")
 	}
 }
+
+func TestStaleRetained(t *testing.T) {
+	err := testMemoryStore.Save(ctx, "stale", parent.Data{
+		Body:        []byte("this is body of a stale key"),
+		Status:      http.StatusOK,
+		ContentType: "text/plain",
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now(),
+		StaleUntil:  time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	time.Sleep(time.Second)
+	time.Sleep(time.Second)
+	_, found, err := testMemoryStore.Get(ctx, "stale")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Error("stale key is purged?")
+	}
+}
+
+func TestInvalidateTags(t *testing.T) {
+	for key, tags := range map[string][]string{
+		"product1": {"product:1", "category:1"},
+		"product2": {"product:2", "category:1"},
+		"product3": {"product:3", "category:2"},
+	} {
+		err := testMemoryStore.Save(ctx, key, parent.Data{
+			Body:      []byte("this is body of " + key),
+			Status:    http.StatusOK,
+			Tags:      tags,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	removed, err := testMemoryStore.InvalidateTags(ctx, "category:1", "product:2")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 2 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	for _, key := range []string{"product1", "product2"} {
+		if _, found := testMemoryStore.items[key]; found {
+			t.Errorf("%s is not invalidated", key)
+		}
+	}
+	if _, found := testMemoryStore.items["product3"]; !found {
+		t.Error("product3 is invalidated")
+	}
+	if _, found := testMemoryStore.tags["category:1"]; found {
+		t.Error("tag is not removed from index")
+	}
+	err = testMemoryStore.Delete(ctx, "product3")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(testMemoryStore.tags) != 0 {
+		t.Errorf("tags index is not empty: %v", testMemoryStore.tags)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	for _, key := range []string{"/catalog/1", "/catalog/2/reviews", "/catalogue", "/posts/1"} {
+		err := testMemoryStore.Save(ctx, key, parent.Data{
+			Body:      []byte("this is body of " + key),
+			Status:    http.StatusOK,
+			Tags:      []string{key},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	removed, err := testMemoryStore.DeletePrefix(ctx, "/catalog/")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 2 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	removed, err = testMemoryStore.DeleteRegexp(ctx, regexp.MustCompile(`^/catalog(ue)?$`))
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	removed, err = testMemoryStore.DeleteMatching(ctx, "/[cp]*")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	if _, found := testMemoryStore.items["/posts/1"]; found {
+		t.Error("/posts/1 is not deleted")
+	}
+	if _, found := testMemoryStore.items["stale"]; !found {
+		t.Error("key not matching pattern is deleted")
+	}
+	if len(testMemoryStore.tags) != 0 {
+		t.Errorf("tags index is not empty: %v", testMemoryStore.tags)
+	}
+}
+
+func TestGenerations(t *testing.T) {
+	generation, err := testMemoryStore.Generation(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != 0 {
+		t.Errorf("wrong initial generation %v", generation)
+	}
+	generation, err = testMemoryStore.BumpGeneration(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != 1 {
+		t.Errorf("wrong bumped generation %v", generation)
+	}
+	generation, err = testMemoryStore.Generation(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != 1 {
+		t.Errorf("wrong generation %v", generation)
+	}
+}
@@ -2,74 +2,470 @@ package gincache
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Good read
-// https://github.com/gin-gonic/gin/issues/1363#issuecomment-577722498
+// New creates new caching middleware with cache and extractor function provided
+func New(
+	cache Cache,
+	keyExtractor func(c *gin.Context) (key string, ttl time.Duration, err error),
+) gin.HandlerFunc {
+	return NewWithOptions(cache, WithKeyExtractor(keyExtractor))
+}
 
-type sniffer struct {
-	gin.ResponseWriter
-	body *bytes.Buffer
+// NewWithOptions creates new caching middleware with cache and options provided
+func NewWithOptions(cache Cache, opts ...Option) gin.HandlerFunc {
+	m := middleware{
+		cache:         cache,
+		options:       defaultOptions(),
+		flights:       newFlightGroup(),
+		revalidations: newFlightGroup(),
+	}
+	for _, opt := range opts {
+		opt(&m.options)
+	}
+	for i := range m.invalidationRules {
+		if !m.invalidationRules[i].supported(cache) {
+			panic(fmt.Sprintf("gincache: invalidation rule for %s %s requires prefixes or tags, that are not supported by caching backend",
+				m.invalidationRules[i].Method, m.invalidationRules[i].Route))
+		}
+	}
+	// diagnostic headers are set by middleware itself, so they should not be saved
+	m.headers.exclude(m.diagnostics.Status, m.diagnostics.Age, m.diagnostics.Timing)
+	if locker, ok := cache.(Locker); ok && m.lockTTL > 0 {
+		m.locker = locker
+	}
+	if generations, ok := cache.(Generations); ok && m.namespace != "" {
+		m.generations = generations
+	}
+	return m.handle
 }
 
-func (s sniffer) Write(b []byte) (int, error) {
-	s.body.Write(b)
-	return s.ResponseWriter.Write(b)
+type middleware struct {
+	cache         Cache
+	locker        Locker
+	generations   Generations
+	flights       *flightGroup
+	revalidations *flightGroup
+	options
 }
 
-func (s sniffer) WriteString(payload string) (n int, err error) {
-	s.body.WriteString(payload)
-	return s.ResponseWriter.WriteString(payload)
+// fail reports error to ErrorHandler and panics, unless middleware is in fail open mode
+func (m *middleware) fail(c *gin.Context, phase Phase, err error) {
+	handler := m.onError
+	if handler == nil && m.failOpen {
+		handler = DefaultErrorHandler
+	}
+	if handler != nil {
+		handler(c, phase, err)
+	}
+	if !m.failOpen {
+		panic(err)
+	}
 }
 
-// New creates new caching middleware with cache and extractor function provided
-func New(
-	cache Cache,
-	keyExtractor func(c *gin.Context) (key string, ttl time.Duration, err error),
-) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// only get request responses can be cached
-		if c.Request.Method != http.MethodGet {
-			c.Next()
+// report reports error to ErrorHandler, or writes it to gin.DefaultErrorWriter, if there is no handler
+func (m *middleware) report(c *gin.Context, phase Phase, err error) {
+	if m.onError != nil {
+		m.onError(c, phase, err)
+		return
+	}
+	DefaultErrorHandler(c, phase, err)
+}
+
+func (m *middleware) handle(c *gin.Context) {
+	// only responses for allowed methods can be cached
+	if !m.methodAllowed(c.Request.Method) {
+		c.Next()
+		m.invalidate(c)
+		return
+	}
+	if m.bypassed(c) {
+		m.diagnostics.status(c, cacheBypass)
+		c.Next()
+		return
+	}
+	key, ttl, err := m.keyExtractor(c)
+	if err != nil {
+		m.fail(c, PhaseExtract, err)
+		c.Next()
+		return
+	}
+	key, err = m.namespaced(c.Request.Context(), key)
+	if err != nil {
+		m.fail(c, PhaseGeneration, err)
+		c.Next()
+		return
+	}
+	// request replayed by middleware to refresh stale response is always rendered
+	if isRevalidation(c) {
+		m.miss(c, key, ttl, nil)
+		return
+	}
+	if m.refresh != nil && m.refresh(c) {
+		if m.onMiss != nil {
+			m.onMiss(c, key)
+		}
+		m.diagnostics.status(c, cacheMiss)
+		m.render(c, key, ttl, nil)
+		return
+	}
+	started := time.Now()
+	data, found, err := m.cache.Get(c.Request.Context(), key)
+	now := time.Now()
+	m.diagnostics.timing(c, now.Sub(started))
+	if err != nil {
+		m.fail(c, PhaseGet, err)
+		c.Next()
+		return
+	}
+	if found && m.fresh(data, now) {
+		m.serve(c, data, cacheHit)
+		return
+	}
+	if found && m.staleWindow > 0 && now.Before(data.StaleUntil) {
+		m.serveStale(c, data, warningStale)
+		m.revalidate(c, key)
+		return
+	}
+	// expired response, that can be served, if handler fails
+	var stale *Data
+	if found && m.staleIfError > 0 && now.Before(data.StaleIfErrorUntil) {
+		stale = &data
+	}
+	if m.coalescing > 0 {
+		f, leader := m.flights.join(key)
+		if leader {
+			defer m.flights.land(key, f)
+			f.data, f.ok = m.miss(c, key, ttl, stale)
+			return
+		}
+		data, ok := f.wait(c.Request.Context(), m.coalescing)
+		if ok {
+			m.serve(c, data, cacheHit)
 			return
 		}
-		key, ttl, err := keyExtractor(c)
+	}
+	m.miss(c, key, ttl, stale)
+}
+
+// namespaced returns key in namespace of middleware, including its current generation
+func (m *middleware) namespaced(ctx context.Context, key string) (string, error) {
+	if m.namespace == "" {
+		return key, nil
+	}
+	var generation uint64
+	if m.generations != nil {
+		var err error
+		generation, err = m.generations.Generation(ctx, m.namespace)
+		if err != nil {
+			return key, err
+		}
+	}
+	return NamespacedKey(m.namespace, generation, key), nil
+}
+
+// invalidate applies invalidation rules matching request, if it was processed successfully. Response is already
+// written to client, so errors are only reported.
+func (m *middleware) invalidate(c *gin.Context) {
+	status := c.Writer.Status()
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return
+	}
+	for i := range m.invalidationRules {
+		if !m.invalidationRules[i].matches(c) {
+			continue
+		}
+		invalidation := m.invalidationRules[i].expand(c.Params)
+		var err error
+		for j := range invalidation.Keys {
+			invalidation.Keys[j], err = m.namespaced(c.Request.Context(), invalidation.Keys[j])
+			if err != nil {
+				m.report(c, PhaseGeneration, err)
+				return
+			}
+		}
+		for j := range invalidation.Prefixes {
+			invalidation.Prefixes[j], err = m.namespaced(c.Request.Context(), invalidation.Prefixes[j])
+			if err != nil {
+				m.report(c, PhaseGeneration, err)
+				return
+			}
+		}
+		_, err = Invalidate(c.Request.Context(), m.cache, invalidation)
 		if err != nil {
-			panic(err)
+			m.report(c, PhaseInvalidate, err)
 		}
-		data, found, err := cache.Get(c.Request.Context(), key)
+	}
+}
+
+// bypassed reports, if client requested response not to be served from cache and not to be saved
+func (m *middleware) bypassed(c *gin.Context) bool {
+	if !m.bypass || !noCacheRequested(c) {
+		return false
+	}
+	return m.authorizeBypass == nil || m.authorizeBypass(c)
+}
+
+func (m *middleware) miss(c *gin.Context, key string, ttl time.Duration, stale *Data) (data Data, ok bool) {
+	if m.locker != nil {
+		token, acquired, err := m.locker.Lock(c.Request.Context(), key, m.lockTTL)
 		if err != nil {
-			panic(err)
+			m.fail(c, PhaseLock, err)
+		} else if acquired {
+			defer m.unlock(c, key, token)
+		} else if isRevalidation(c) {
+			// other process is already refreshing response
+			return
+		} else {
+			data, found := m.poll(c, key)
+			if found {
+				m.serve(c, data, cacheHit)
+				return data, true
+			}
+		}
+	}
+	if m.onMiss != nil {
+		m.onMiss(c, key)
+	}
+	m.diagnostics.status(c, cacheMiss)
+	return m.render(c, key, ttl, stale)
+}
+
+// unlockTimeout is maximum duration of releasing lock
+const unlockTimeout = 5 * time.Second
+
+// unlock releases lock for key, even if client has disconnected, so it is not held until its ttl is over
+func (m *middleware) unlock(c *gin.Context, key, token string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), unlockTimeout)
+	defer cancel()
+	err := m.locker.Unlock(ctx, key, token)
+	if err != nil {
+		m.fail(c, PhaseUnlock, err)
+	}
+}
+
+// fresh reports, if cached response has not expired yet. Backends are expected to purge expired responses,
+// unless they are kept to be served stale.
+func (m *middleware) fresh(data Data, now time.Time) bool {
+	if m.staleWindow == 0 && m.staleIfError == 0 {
+		return true
+	}
+	return now.Before(data.ExpiresAt)
+}
+
+// poll waits for response rendered by process holding lock for key to be saved in cache
+func (m *middleware) poll(c *gin.Context, key string) (Data, bool) {
+	timeout := time.NewTimer(m.lockTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(m.lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return Data{}, false
+		case <-timeout.C:
+			return Data{}, false
+		case <-ticker.C:
+			data, found, err := m.cache.Get(c.Request.Context(), key)
+			if err != nil {
+				m.fail(c, PhaseGet, err)
+				return data, false
+			}
+			// stale response can be present in cache, while it is being refreshed
+			if found && m.fresh(data, time.Now()) {
+				return data, true
+			}
 		}
-		if found {
-			c.Header("Last-Modified", data.CreatedAt.Format(time.RFC1123))
-			c.Header("Expires", data.ExpiresAt.Format(time.RFC1123))
-			c.Data(data.Status, data.ContentType, data.Body)
-			c.Abort()
+	}
+}
+
+// serve writes cached data to client, reporting cache status provided
+func (m *middleware) serve(c *gin.Context, data Data, status string) {
+	if m.expirationHeaders {
+		c.Header("Last-Modified", httpDate(data.CreatedAt))
+		c.Header("Expires", httpDate(data.ExpiresAt))
+	}
+	if m.onHit != nil {
+		m.onHit(c, data)
+	}
+	// headers set for current request by other middleware are kept
+	header := c.Writer.Header()
+	for name, values := range data.Headers {
+		if _, set := header[name]; !set {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+	m.diagnostics.status(c, status)
+	m.diagnostics.age(c, data.CreatedAt)
+	if data.ETag != "" {
+		c.Header("ETag", data.ETag)
+	}
+	// If-Modified-Since is compared with Last-Modified, only if it was sent
+	var lastModified time.Time
+	if m.expirationHeaders {
+		lastModified = data.CreatedAt
+	}
+	if data.Status == http.StatusOK && notModified(c.Request, data.ETag, lastModified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Data(data.Status, data.ContentType, data.Body)
+	c.Abort()
+}
+
+const (
+	// warningStale is RFC 7234 warning for stale responses served while they are revalidated
+	warningStale = `110 - "Response is Stale"`
+	// warningRevalidationFailed is RFC 7234 warning for stale responses served because handler failed
+	warningRevalidationFailed = `111 - "Revalidation Failed"`
+)
+
+// serveStale writes expired cached data to client with warning provided
+func (m *middleware) serveStale(c *gin.Context, data Data, warning string) {
+	c.Header("Warning", warning)
+	m.serve(c, data, cacheStale)
+}
+
+// next executes pending handlers, recovering from their panic, if recoverPanic is true
+func next(c *gin.Context, recoverPanic bool) (recovered any) {
+	if recoverPanic {
+		defer func() {
+			recovered = recover()
+		}()
+	}
+	c.Next()
+	return
+}
+
+// render passes request to handler, saves response in cache and returns it, if it is cacheable.
+// If stale response is provided, it is served instead of response with 5xx status code, or if handler panics.
+func (m *middleware) render(c *gin.Context, key string, ttl time.Duration, stale *Data) (data Data, ok bool) {
+	now := time.Now()
+	buffered := m.buffered || m.etag || stale != nil
+	// without buffering, headers are sent before handler writes body
+	var ownExpires string
+	if m.expirationHeaders && !buffered {
+		ownExpires = httpDate(now.Add(ttl))
+		c.Header("Last-Modified", httpDate(now))
+		c.Header("Expires", ownExpires)
+	}
+	original := c.Writer
+	defer func() {
+		c.Writer = original
+	}()
+	var rec recorder
+	if buffered {
+		rec = newBufferedWriter(original, m.maxBodySize)
+	} else {
+		rec = &sniffer{header: original.Header().Clone(), body: &bytes.Buffer{}, ResponseWriter: original, limit: m.maxBodySize}
+	}
+	c.Writer = rec
+	recovered := next(c, stale != nil)
+	c.Writer = original
+	status := rec.Status()
+	if stale != nil && (recovered != nil || status >= http.StatusInternalServerError) {
+		if rec.(*bufferedWriter).reset() {
+			if recovered != nil {
+				m.report(c, PhaseHandler, fmt.Errorf("%v : handler panicked", recovered))
+			}
+			m.serveStale(c, *stale, warningRevalidationFailed)
 			return
 		}
-		now := time.Now()
-		c.Header("Last-Modified", now.Format(time.RFC1123))
-		c.Header("Expires", now.Add(ttl).Format(time.RFC1123))
-		s := &sniffer{body: &bytes.Buffer{}, ResponseWriter: c.Writer}
-		c.Writer = s
-		c.Next()
-		// saving sniffed body
-		newDataToBeSaved := Data{
-			Key:         key,
-			Body:        s.body.Bytes(),
-			Status:      c.Writer.Status(),
-			ContentType: c.Writer.Header().Get("Content-Type"),
-			CreatedAt:   time.Now(),
-			ExpiresAt:   time.Now().Add(ttl),
+		if recovered != nil {
+			panic(recovered)
 		}
-		err = cache.Save(c.Request.Context(), key, newDataToBeSaved)
-		if err != nil {
-			panic(err)
+	}
+	body, complete := rec.recorded()
+	var etag string
+	if m.etag && complete {
+		etag = ETag(body)
+	}
+	ttl, cacheable := m.ttlForStatus(status, ttl)
+	if cacheable {
+		ttl, cacheable = responseTTL(rec.Header(), ttl, now, ownExpires)
+	}
+	if cacheable {
+		ttl, cacheable = directives(c, ttl)
+	}
+	if cacheable && m.shouldCache != nil {
+		cacheable = m.shouldCache(c)
+	}
+	if bw, ok := rec.(*bufferedWriter); ok && complete {
+		m.flush(c, bw, status, len(body), etag, now, ttl, cacheable)
+	}
+	if !complete || !cacheable {
+		return
+	}
+	// saving recorded body
+	data = Data{
+		Key:         key,
+		Body:        body,
+		Status:      status,
+		ContentType: original.Header().Get("Content-Type"),
+		Headers:     m.headers.filter(original.Header(), rec.initialHeader()),
+		ETag:        etag,
+		Tags:        tags(c, rec.Header()),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+	if m.staleWindow > 0 {
+		data.StaleUntil = data.ExpiresAt.Add(m.staleWindow)
+	}
+	if m.staleIfError > 0 {
+		data.StaleIfErrorUntil = data.ExpiresAt.Add(m.staleIfError)
+	}
+	err := m.cache.Save(c.Request.Context(), key, data)
+	if err != nil {
+		m.fail(c, PhaseSave, err)
+		return data, true
+	}
+	if m.onSave != nil {
+		m.onSave(c, data)
+	}
+	return data, true
+}
+
+// flush sets response headers, that depend on response rendered, and writes buffered response to client
+func (m *middleware) flush(c *gin.Context, bw *bufferedWriter, status, size int, etag string, now time.Time, ttl time.Duration, cacheable bool) {
+	// If-Modified-Since is compared with Last-Modified, only if it was sent
+	var lastModified time.Time
+	if cacheable {
+		if m.expirationHeaders {
+			lastModified = now
+			c.Header("Last-Modified", httpDate(now))
+			c.Header("Expires", httpDate(now.Add(ttl)))
 		}
+		m.diagnostics.age(c, now)
+	}
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if status == http.StatusOK && notModified(c.Request, etag, lastModified) {
+		bw.discard(http.StatusNotModified)
+		return
+	}
+	if bodyAllowedForStatus(status) {
+		c.Header("Content-Length", strconv.Itoa(size))
+	}
+	bw.commit()
+}
+
+// bodyAllowedForStatus reports, if response with status provided can have body, as RFC 7230 section 3.3 states
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
 	}
+	return true
 }
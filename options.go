@@ -0,0 +1,306 @@
+package gincache
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultLockPollInterval is interval of polling cache for response rendered by process holding lock,
+// if WithDistributedLock is called with zero pollInterval
+const DefaultLockPollInterval = 50 * time.Millisecond
+
+// KeyExtractor is function that returns cache key and ttl for request
+type KeyExtractor func(c *gin.Context) (key string, ttl time.Duration, err error)
+
+// Option configures caching middleware created by NewWithOptions
+type Option func(o *options)
+
+type options struct {
+	keyExtractor      KeyExtractor
+	onError           ErrorHandler
+	failOpen          bool
+	methods           []string
+	statusPolicy      StatusPolicy
+	maxBodySize       int
+	expirationHeaders bool
+	headers           headerFilter
+	etag              bool
+	buffered          bool
+	coalescing        time.Duration
+	lockTTL           time.Duration
+	lockTimeout       time.Duration
+	lockPollInterval  time.Duration
+	staleWindow       time.Duration
+	engine            *gin.Engine
+	staleIfError      time.Duration
+	bypass            bool
+	authorizeBypass   func(c *gin.Context) bool
+	refresh           func(c *gin.Context) bool
+	diagnostics       DiagnosticHeaders
+	shouldCache       func(c *gin.Context) bool
+	namespace         string
+	invalidationRules []InvalidationRule
+	onHit             func(c *gin.Context, data Data)
+	onMiss            func(c *gin.Context, key string)
+	onSave            func(c *gin.Context, data Data)
+}
+
+func defaultOptions() options {
+	return options{
+		keyExtractor:      CacheByPath(time.Minute),
+		methods:           []string{http.MethodGet},
+		statusPolicy:      DefaultStatusPolicy,
+		shouldCache:       DefaultShouldCache,
+		expirationHeaders: true,
+	}
+}
+
+func (o *options) methodAllowed(method string) bool {
+	for i := range o.methods {
+		if o.methods[i] == method {
+			return true
+		}
+	}
+	return false
+}
+
+// ttlForStatus returns ttl for response with status provided, server errors are never cached
+func (o *options) ttlForStatus(status int, ttl time.Duration) (time.Duration, bool) {
+	if status >= http.StatusInternalServerError {
+		return 0, false
+	}
+	newTTL, cacheable := o.statusPolicy(status, ttl)
+	if newTTL <= 0 {
+		return 0, false
+	}
+	return newTTL, cacheable
+}
+
+// WithKeyExtractor sets function to extract cache key and ttl from request. Default one is CacheByPath(time.Minute)
+func WithKeyExtractor(keyExtractor KeyExtractor) Option {
+	return func(o *options) {
+		o.keyExtractor = keyExtractor
+	}
+}
+
+// OnError sets handler to be called when key extractor or caching backend returns error
+func OnError(handler ErrorHandler) Option {
+	return func(o *options) {
+		o.onError = handler
+	}
+}
+
+// FailOpen makes middleware tolerate errors - if key cannot be extracted or cache cannot be read,
+// request is passed to handler, and if response cannot be saved, error is only reported to ErrorHandler.
+// Without this option, middleware panics on every error.
+func FailOpen() Option {
+	return func(o *options) {
+		o.failOpen = true
+	}
+}
+
+// WithMethods sets request methods, responses for which are cached. Default is GET only.
+// Take care, that responses for HEAD requests have no body, so they should not share cache keys with GET ones.
+func WithMethods(methods ...string) Option {
+	return func(o *options) {
+		o.methods = methods
+	}
+}
+
+// WithStatusCodes sets response status codes that can be cached. Responses with other codes are not saved.
+// Default ones are DefaultCacheableStatusCodes, so only successful responses are cached.
+func WithStatusCodes(codes ...int) Option {
+	return WithStatusPolicy(StatusCodes(codes...))
+}
+
+// WithStatusTTL sets response status codes that can be cached with their own ttl
+func WithStatusTTL(ttls map[int]time.Duration) Option {
+	return WithStatusPolicy(StatusTTL(ttls))
+}
+
+// WithStatusPolicy sets policy to decide, if response can be cached depending on its status code, for example,
+// HeuristicStatusPolicy(5*time.Second) caches 404 Not Found responses and redirects for 5 seconds
+func WithStatusPolicy(policy StatusPolicy) Option {
+	return func(o *options) {
+		o.statusPolicy = policy
+	}
+}
+
+// WithMaxBodySize sets maximum response body size in bytes that can be cached. Zero means no limit.
+func WithMaxBodySize(size int) Option {
+	return func(o *options) {
+		o.maxBodySize = size
+	}
+}
+
+// WithExpirationHeaders enables or disables setting Last-Modified and Expires response headers. They are enabled by default.
+func WithExpirationHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.expirationHeaders = enabled
+	}
+}
+
+// WithHeaderAllowList sets names of response headers to be saved in cache. By default, all headers are saved,
+// except Set-Cookie, hop-by-hop headers and ones managed by middleware itself.
+func WithHeaderAllowList(names ...string) Option {
+	return func(o *options) {
+		o.headers.allow = newHeaderSet(names)
+	}
+}
+
+// WithHeaderDenyList sets names of response headers to be never saved in cache
+func WithHeaderDenyList(names ...string) Option {
+	return func(o *options) {
+		o.headers.deny = newHeaderSet(names)
+	}
+}
+
+// WithETag enables generation of strong ETag for cached responses, and answering requests with matching
+// If-None-Match header with 304 Not Modified. Responses are buffered until handler completes, so it is
+// not suitable for streaming responses.
+func WithETag() Option {
+	return func(o *options) {
+		o.etag = true
+	}
+}
+
+// WithBufferedResponse makes middleware buffer response on cache miss, until handler completes, instead of
+// writing it to client and recording at the same time. It allows middleware to set Expires, ETag,
+// Content-Length and Age headers according to response rendered. Responses larger than WithMaxBodySize,
+// or flushed by handler, are written to client directly and are not cached.
+func WithBufferedResponse() Option {
+	return func(o *options) {
+		o.buffered = true
+	}
+}
+
+// WithCoalescing enables coalescing of concurrent requests for the same cache key, so only one of them is
+// passed to handler, while others wait for its response. If response cannot be cached, or it is not rendered
+// until timeout is reached, waiting requests are passed to handler.
+func WithCoalescing(timeout time.Duration) Option {
+	return func(o *options) {
+		o.coalescing = timeout
+	}
+}
+
+// WithDistributedLock makes middleware acquire lock for cache key before passing request to handler,
+// if Cache implements Locker, so only one of few processes sharing cache renders response. Other processes
+// poll cache every pollInterval until response is saved or timeout is reached, and then pass request to handler.
+// Lock is released after response is saved, or when ttl is over. Zero timeout means ttl is used as timeout,
+// and zero pollInterval means DefaultLockPollInterval.
+func WithDistributedLock(ttl, timeout, pollInterval time.Duration) Option {
+	return func(o *options) {
+		if timeout <= 0 {
+			timeout = ttl
+		}
+		if pollInterval <= 0 {
+			pollInterval = DefaultLockPollInterval
+		}
+		o.lockTTL = ttl
+		o.lockTimeout = timeout
+		o.lockPollInterval = pollInterval
+	}
+}
+
+// WithStaleWhileRevalidate enables RFC 5861 stale-while-revalidate semantics - expired response is kept
+// in cache for window provided, and it is served immediately, while request is replayed against engine
+// in background to refresh it. It panics, if engine is nil.
+func WithStaleWhileRevalidate(window time.Duration, engine *gin.Engine) Option {
+	if engine == nil {
+		panic("gincache: engine to revalidate stale responses is nil")
+	}
+	return func(o *options) {
+		o.staleWindow = window
+		o.engine = engine
+	}
+}
+
+// WithStaleIfError enables RFC 5861 stale-if-error semantics - expired response is kept in cache for
+// window provided, and if handler responds with 5xx status code or panics, it is served instead
+// with Warning header, while panic is reported to ErrorHandler with PhaseHandler, or written to
+// gin.DefaultErrorWriter. Responses are buffered until handler completes, if stale response is present.
+func WithStaleIfError(window time.Duration) Option {
+	return func(o *options) {
+		o.staleIfError = window
+	}
+}
+
+// WithNoCacheBypass makes middleware honour Cache-Control: no-cache and Pragma: no-cache request headers -
+// such requests are passed to handler, and their responses are not saved. If authorize predicate is provided,
+// only requests it approves can bypass cache.
+func WithNoCacheBypass(authorize func(c *gin.Context) bool) Option {
+	return func(o *options) {
+		o.bypass = true
+		o.authorizeBypass = authorize
+	}
+}
+
+// WithRefresh makes middleware pass requests, that trigger predicate approves, to handler, and overwrite
+// cached response with one rendered, for example
+//
+//	cache.WithRefresh(cache.RequireHeader("X-Cache-Refresh", os.Getenv("CACHE_REFRESH_SECRET")))
+func WithRefresh(trigger func(c *gin.Context) bool) Option {
+	return func(o *options) {
+		o.refresh = trigger
+	}
+}
+
+// WithDiagnosticHeaders enables headers, that report cache status, age of cached response and caching backend
+// lookup latency, with names provided, for example, DefaultDiagnosticHeaders. They are disabled by default,
+// because Server-Timing exposes backend latency to clients.
+func WithDiagnosticHeaders(headers DiagnosticHeaders) Option {
+	return func(o *options) {
+		o.diagnostics = headers
+	}
+}
+
+// WithShouldCache sets predicate, that is called after handler is executed, to decide, if response can be saved.
+// Default one is DefaultShouldCache, that forbids saving responses, if handler reported errors.
+func WithShouldCache(predicate func(c *gin.Context) bool) Option {
+	return func(o *options) {
+		o.shouldCache = predicate
+	}
+}
+
+// WithNamespace makes middleware cache responses in namespace provided. If Cache implements Generations,
+// keys include current generation of namespace, so all responses in it can be invalidated by single
+// BumpGeneration call.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithInvalidationRules sets rules to invalidate cached responses after successful (2xx) requests,
+// responses for which are not cached, like POST, PUT or DELETE ones. Response is already written to client,
+// when rules are applied, so their errors are reported to ErrorHandler, or written to gin.DefaultErrorWriter,
+// even without FailOpen. NewWithOptions panics, if rule has prefixes or tags, and Cache does not implement
+// PrefixDeleter or TagInvalidator.
+func WithInvalidationRules(rules ...InvalidationRule) Option {
+	return func(o *options) {
+		o.invalidationRules = append(o.invalidationRules, rules...)
+	}
+}
+
+// OnHit sets hook to be called when response is served from cache
+func OnHit(hook func(c *gin.Context, data Data)) Option {
+	return func(o *options) {
+		o.onHit = hook
+	}
+}
+
+// OnMiss sets hook to be called when response is not found in cache and request is passed to handler
+func OnMiss(hook func(c *gin.Context, key string)) Option {
+	return func(o *options) {
+		o.onMiss = hook
+	}
+}
+
+// OnSave sets hook to be called after response is saved in cache
+func OnSave(hook func(c *gin.Context, data Data)) Option {
+	return func(o *options) {
+		o.onSave = hook
+	}
+}
@@ -0,0 +1,164 @@
+package rcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	parent "github.com/vodolaz095/gin-cache"
+)
+
+// DefaultBusChannel is redis pub/sub channel, that invalidation events are published on by default
+const DefaultBusChannel = "gin-cache:invalidation"
+
+// EventType is type of invalidation event
+type EventType string
+
+const (
+	// EventDelete means responses with keys listed in event are deleted
+	EventDelete EventType = "delete"
+	// EventTags means responses tagged by tags listed in event are invalidated
+	EventTags EventType = "tags"
+	// EventFlush means all responses are deleted
+	EventFlush EventType = "flush"
+)
+
+// Event is invalidation event published on Bus
+type Event struct {
+	// Origin is identifier of Bus, that published event
+	Origin string    `json:"origin"`
+	Type   EventType `json:"type"`
+	Keys   []string  `json:"keys,omitempty"`
+	Tags   []string  `json:"tags,omitempty"`
+}
+
+// Bus broadcasts invalidation events between few application instances, that cache responses in their
+// own local caching backends, like memory.Cache, using redis pub/sub channel
+type Bus struct {
+	origin  string
+	channel string
+	client  *redis.Client
+}
+
+// NewBus creates new invalidation bus using redis pub/sub channel provided, empty channel means DefaultBusChannel
+func NewBus(redisConnectionString, channel string) (bus *Bus, err error) {
+	if channel == "" {
+		channel = DefaultBusChannel
+	}
+	buf := make([]byte, 16)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return
+	}
+	opts, err := ParseConnectionString(redisConnectionString)
+	if err != nil {
+		return
+	}
+	bus = &Bus{
+		origin:  hex.EncodeToString(buf),
+		channel: channel,
+		client:  redis.NewClient(&opts),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = bus.client.Ping(ctx).Err()
+	if err != nil {
+		bus.client.Close()
+		return nil, err
+	}
+	return bus, nil
+}
+
+// Origin returns unique identifier of bus, that is set as Origin of events it publishes
+func (b *Bus) Origin() string {
+	return b.origin
+}
+
+// PublishDelete publishes event to delete responses with keys provided
+func (b *Bus) PublishDelete(ctx context.Context, keys ...string) error {
+	return b.publish(ctx, Event{Type: EventDelete, Keys: keys})
+}
+
+// PublishTags publishes event to invalidate responses tagged by tags provided
+func (b *Bus) PublishTags(ctx context.Context, tags ...string) error {
+	return b.publish(ctx, Event{Type: EventTags, Tags: tags})
+}
+
+// PublishFlush publishes event to delete all responses
+func (b *Bus) PublishFlush(ctx context.Context) error {
+	return b.publish(ctx, Event{Type: EventFlush})
+}
+
+func (b *Bus) publish(ctx context.Context, event Event) error {
+	event.Origin = b.origin
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%s : while encoding invalidation event", err)
+	}
+	return b.client.Publish(ctx, b.channel, payload).Err()
+}
+
+// Subscribe applies invalidation events published by other buses on the same channel to local caching
+// backend, until context is canceled. Events published by this bus are skipped, because publisher is
+// expected to invalidate its own local cache. Tag invalidation requires local cache to implement
+// parent.TagInvalidator, and flush requires it to implement parent.PrefixDeleter. Errors of applying
+// events are passed to onError, if it is not nil.
+func (b *Bus) Subscribe(ctx context.Context, local parent.Cache, onError func(event Event, err error)) (err error) {
+	subscription := b.client.Subscribe(ctx, b.channel)
+	defer subscription.Close()
+	_, err = subscription.Receive(ctx)
+	if err != nil {
+		return fmt.Errorf("%s : while subscribing to %s", err, b.channel)
+	}
+	messages := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var event Event
+			errU := json.Unmarshal([]byte(message.Payload), &event)
+			if errU != nil {
+				if onError != nil {
+					onError(event, fmt.Errorf("%s : while decoding invalidation event", errU))
+				}
+				continue
+			}
+			if event.Origin == b.origin {
+				continue
+			}
+			errA := apply(ctx, local, event)
+			if errA != nil && onError != nil {
+				onError(event, errA)
+			}
+		}
+	}
+}
+
+// Close closes connection to redis
+func (b *Bus) Close() error {
+	return b.client.Close()
+}
+
+// apply applies invalidation event to local caching backend
+func apply(ctx context.Context, local parent.Cache, event Event) (err error) {
+	switch event.Type {
+	case EventDelete:
+		_, err = parent.Invalidate(ctx, local, parent.Invalidation{Keys: event.Keys})
+	case EventTags:
+		_, err = parent.Invalidate(ctx, local, parent.Invalidation{Tags: event.Tags})
+	case EventFlush:
+		_, err = parent.Invalidate(ctx, local, parent.Invalidation{Prefixes: []string{""}})
+	default:
+		err = fmt.Errorf("unknown invalidation event type %s", event.Type)
+	}
+	return
+}
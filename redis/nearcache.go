@@ -0,0 +1,341 @@
+package rcache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	parent "github.com/vodolaz095/gin-cache"
+)
+
+// invalidationChannel is channel, that redis sends key invalidation messages to, when client tracking
+// is redirected to connection subscribed to it
+const invalidationChannel = "__redis__:invalidate"
+
+// trackingCheckInterval is interval of checking connection, that tracks items for near cache
+const trackingCheckInterval = time.Second
+
+// WithNearCache enables client side caching - up to size recently read items are kept in process memory,
+// so they are served without network round trips. Dedicated connection to redis enables CLIENT TRACKING
+// in broadcasting mode for keys starting with prefix, so redis notifies other dedicated connection about
+// every change of them, and local copies are invalidated. Keys of locks, tag sets and generations do not
+// start with prefix, so they are not tracked. If tracking connection is lost, local copies are dropped,
+// and they are not kept until it is restored. If connection receiving notifications is lost, local copies
+// are dropped, and near cache is disabled, until caching driver is recreated, because redis cannot
+// notify it anymore.
+func WithNearCache(size int) Option {
+	return func(rc *Cache) {
+		if size > 0 {
+			rc.near = newNearCache(size)
+		}
+	}
+}
+
+// nearCache is bounded local copy of items recently read from redis, invalidated by redis tracking
+// messages. Its methods are safe to call on nil receiver, that means near cache is disabled.
+type nearCache struct {
+	sync.Mutex
+	size  int
+	items map[string]*list.Element
+	// order is list of nearEntry, most recently used ones are in front
+	order *list.List
+	// fetches are items being read from redis, so their invalidations received before them are detected
+	fetches map[string]*nearFetch
+	// epoch is incremented, when all local copies are dropped, so items read before it are not saved
+	epoch uint64
+	// tracking reports, if redis tracks items for near cache now
+	tracking bool
+	// registered reports, if tracking was ever enabled, so changes could be missed, when it is enabled again
+	registered bool
+	disabled   bool
+	redirectID int64
+	subscriber *redis.Client
+	pubsub     *redis.PubSub
+	tracker    *redis.Client
+	done       chan struct{}
+	stop       sync.Once
+}
+
+type nearEntry struct {
+	key  string
+	data parent.Data
+}
+
+// nearFetch is state of item being read from redis
+type nearFetch struct {
+	// pending is number of concurrent reads of item
+	pending int
+	// version is incremented on every invalidation of item
+	version uint64
+}
+
+// nearTicket is state of near cache and item, when item started being read from redis
+type nearTicket struct {
+	epoch   uint64
+	version uint64
+}
+
+func newNearCache(size int) *nearCache {
+	return &nearCache{
+		size:    size,
+		items:   make(map[string]*list.Element, size),
+		order:   list.New(),
+		fetches: make(map[string]*nearFetch),
+		done:    make(chan struct{}),
+	}
+}
+
+// start subscribes dedicated connection to invalidation messages, and makes other dedicated connection
+// redirect tracking messages for keys starting with prefix to it
+func (n *nearCache) start(ctx context.Context, opts redis.Options, prefix string) (err error) {
+	subscriberOpts := opts
+	// with RESP3 redis sends invalidate push messages, that redis.PubSub cannot parse, while with RESP2
+	// they are sent as usual messages of channel subscribed
+	subscriberOpts.Protocol = 2
+	subscriberOpts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		id, errID := cn.ClientID(ctx).Result()
+		if errID != nil {
+			return errID
+		}
+		n.redirect(id)
+		return nil
+	}
+	n.subscriber = redis.NewClient(&subscriberOpts)
+	n.pubsub = n.subscriber.Subscribe(ctx, invalidationChannel)
+	_, err = n.pubsub.Receive(ctx)
+	if err != nil {
+		n.close()
+		return fmt.Errorf("%s : while subscribing to %s", err, invalidationChannel)
+	}
+	// in broadcasting mode redis notifies about changes made by any client, so tracking is enabled once,
+	// on connection, that is never closed for being idle
+	trackerOpts := opts
+	trackerOpts.PoolSize = 1
+	trackerOpts.ConnMaxIdleTime = -1
+	trackerOpts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		return n.track(ctx, cn, prefix)
+	}
+	n.tracker = redis.NewClient(&trackerOpts)
+	err = n.tracker.Ping(ctx).Err()
+	if err != nil {
+		n.close()
+		return fmt.Errorf("%s : while enabling client tracking", err)
+	}
+	n.Lock()
+	n.tracking = true
+	n.Unlock()
+	go n.listen()
+	go n.check()
+	return nil
+}
+
+// track enables tracking of keys starting with prefix on connection provided. If tracking was enabled
+// before on connection lost, all local copies are dropped, because their changes could be missed.
+func (n *nearCache) track(ctx context.Context, cn *redis.Conn, prefix string) error {
+	n.Lock()
+	args := []interface{}{"CLIENT", "TRACKING", "ON", "REDIRECT", n.redirectID, "BCAST"}
+	n.Unlock()
+	if prefix != "" {
+		args = append(args, "PREFIX", prefix)
+	}
+	err := cn.Do(ctx, args...).Err()
+	if err != nil {
+		return err
+	}
+	n.Lock()
+	defer n.Unlock()
+	if n.registered {
+		n.purge()
+	}
+	n.registered = true
+	return nil
+}
+
+// check pings tracking connection, until near cache is closed, so it is restored soon, if it is lost.
+// Local copies are not kept, while ping fails.
+func (n *nearCache) check() {
+	ticker := time.NewTicker(trackingCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), trackingCheckInterval)
+			err := n.tracker.Ping(ctx).Err()
+			cancel()
+			n.Lock()
+			if err != nil {
+				n.purge()
+			}
+			n.tracking = err == nil
+			n.Unlock()
+		}
+	}
+}
+
+// redirect records identifier of connection subscribed to invalidation messages. If it is changed due to
+// reconnection, connections tracking keys still redirect messages to old one, so near cache is disabled.
+func (n *nearCache) redirect(id int64) {
+	n.Lock()
+	defer n.Unlock()
+	if n.redirectID != 0 && n.redirectID != id {
+		n.disabled = true
+		n.purge()
+	}
+	n.redirectID = id
+}
+
+// listen applies invalidation messages, until subscription is closed
+func (n *nearCache) listen() {
+	var failures int
+	for {
+		msg, err := n.pubsub.Receive(context.Background())
+		if errors.Is(err, redis.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// flush notification has nil payload, that cannot be parsed, so every unexpected
+			// message or connection error drops all local copies
+			n.Lock()
+			n.purge()
+			n.Unlock()
+			if failures > 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+			failures++
+			continue
+		}
+		failures = 0
+		message, ok := msg.(*redis.Message)
+		if !ok {
+			continue
+		}
+		if message.Payload != "" {
+			n.invalidate(message.Payload)
+		} else {
+			n.invalidate(message.PayloadSlice...)
+		}
+	}
+}
+
+// get returns local copy of item, if it is present and not expired
+func (n *nearCache) get(key string, now time.Time) (data parent.Data, found bool) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	if n.disabled || !n.tracking {
+		return
+	}
+	element, found := n.items[key]
+	if !found {
+		return
+	}
+	entry := element.Value.(*nearEntry)
+	if !entry.data.RetainUntil().IsZero() && !now.Before(entry.data.RetainUntil()) {
+		n.remove(element)
+		return parent.Data{}, false
+	}
+	n.order.MoveToFront(element)
+	return entry.data, true
+}
+
+// begin registers read of item from redis, it should be called before item is read, and followed by put
+func (n *nearCache) begin(key string) (ticket nearTicket) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	fetch, found := n.fetches[key]
+	if !found {
+		fetch = &nearFetch{}
+		n.fetches[key] = fetch
+	}
+	fetch.pending++
+	return nearTicket{epoch: n.epoch, version: fetch.version}
+}
+
+// put completes read of item registered by begin, and saves local copy of item, if it is found, unless item
+// was invalidated since begin was called, because invalidation could be received before item itself
+func (n *nearCache) put(key string, data parent.Data, found bool, ticket nearTicket) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	fetch := n.fetches[key]
+	fetch.pending--
+	if fetch.pending == 0 {
+		delete(n.fetches, key)
+	}
+	if !found || n.disabled || !n.tracking || n.epoch != ticket.epoch || fetch.version != ticket.version {
+		return
+	}
+	element, found := n.items[key]
+	if found {
+		element.Value.(*nearEntry).data = data
+		n.order.MoveToFront(element)
+		return
+	}
+	n.items[key] = n.order.PushFront(&nearEntry{key: key, data: data})
+	for n.order.Len() > n.size {
+		n.remove(n.order.Back())
+	}
+}
+
+// invalidate drops local copies of items with prefixed keys provided
+func (n *nearCache) invalidate(keys ...string) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	for _, key := range keys {
+		element, found := n.items[key]
+		if found {
+			n.remove(element)
+		}
+		fetch, found := n.fetches[key]
+		if found {
+			fetch.version++
+		}
+	}
+}
+
+// remove drops list element, it should be called with lock held
+func (n *nearCache) remove(element *list.Element) {
+	delete(n.items, element.Value.(*nearEntry).key)
+	n.order.Remove(element)
+}
+
+// purge drops all local copies, it should be called with lock held
+func (n *nearCache) purge() {
+	n.epoch++
+	n.items = make(map[string]*list.Element, n.size)
+	n.order.Init()
+}
+
+// close closes subscription to invalidation messages and tracking connection
+func (n *nearCache) close() (err error) {
+	if n == nil {
+		return nil
+	}
+	n.stop.Do(func() {
+		close(n.done)
+		if n.subscriber != nil {
+			err = errors.Join(n.pubsub.Close(), n.subscriber.Close())
+		}
+		if n.tracker != nil {
+			err = errors.Join(err, n.tracker.Close())
+		}
+	})
+	return
+}
@@ -2,8 +2,13 @@ package rcache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -20,8 +25,12 @@ const DefaultConnectionString = "redis://127.0.0.1:6379/0"
 type Cache struct {
 	prefix string
 	client *redis.Client
+	near   *nearCache
 }
 
+// Option configures redis caching driver created by New
+type Option func(rc *Cache)
+
 // ParseConnectionString parses connection string to generate redis connection options
 func ParseConnectionString(connectionString string) (options redis.Options, err error) {
 	u, err := url.Parse(connectionString)
@@ -55,47 +64,131 @@ func ParseConnectionString(connectionString string) (options redis.Options, err
 }
 
 // New creates new redis caching driver
-func New(redisConnectionString, prefix string) (rc *Cache, err error) {
+func New(redisConnectionString, prefix string, options ...Option) (rc *Cache, err error) {
 	rc = &Cache{prefix: prefix}
+	for _, option := range options {
+		option(rc)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	opts, err := ParseConnectionString(redisConnectionString)
 	if err != nil {
 		return
 	}
+	if rc.near != nil {
+		for _, key := range []string{rc.lockKey(""), rc.tagKey(""), rc.generationKey("")} {
+			if strings.HasPrefix(key, prefix) {
+				err = fmt.Errorf("near cache cannot track items with prefix %q, that key %q starts with", prefix, key)
+				return
+			}
+		}
+		err = rc.near.start(ctx, opts, prefix)
+		if err != nil {
+			return
+		}
+	}
 	rc.client = redis.NewClient(&opts)
 	pong, err := rc.client.Ping(ctx).Result()
 	if err != nil {
+		rc.Close()
 		return
 	}
 	if pong != "PONG" {
+		rc.Close()
 		err = fmt.Errorf("wrong ping response")
 		return
 	}
 	return rc, nil
 }
 
+// Close closes connections to redis
+func (rc *Cache) Close() error {
+	return errors.Join(rc.near.close(), rc.client.Close())
+}
+
 // Save saves item in cache
 func (rc *Cache) Save(ctx context.Context, key string, data parent.Data) (err error) {
 	prefixedKey := fmt.Sprintf("%s%s", rc.prefix, key)
-	_, err = rc.client.HMSet(ctx, prefixedKey, map[string]interface{}{
+	fields := map[string]interface{}{
 		"key":         key,
 		"body":        string(data.Body),
 		"status":      fmt.Sprintf("%v", data.Status),
 		"contentType": data.ContentType,
-		"createdAt":   data.CreatedAt.Format(time.RFC1123),
-		"expiresAt":   data.ExpiresAt.Format(time.RFC1123),
-	}).Result()
-	if err != nil {
-		return
+		"etag":        data.ETag,
+		"createdAt":   formatTime(data.CreatedAt),
+		"expiresAt":   formatTime(data.ExpiresAt),
+	}
+	if len(data.Headers) > 0 {
+		headers, errM := json.Marshal(data.Headers)
+		if errM != nil {
+			return fmt.Errorf("%s : while encoding headers", errM)
+		}
+		fields["headers"] = string(headers)
 	}
-	_, err = rc.client.ExpireAt(ctx, prefixedKey, data.ExpiresAt).Result()
+	if len(data.Tags) > 0 {
+		tags, errM := json.Marshal(data.Tags)
+		if errM != nil {
+			return fmt.Errorf("%s : while encoding tags", errM)
+		}
+		fields["tags"] = string(tags)
+	}
+	if !data.StaleUntil.IsZero() {
+		fields["staleUntil"] = formatTime(data.StaleUntil)
+	}
+	if !data.StaleIfErrorUntil.IsZero() {
+		fields["staleIfErrorUntil"] = formatTime(data.StaleIfErrorUntil)
+	}
+	pipe := rc.client.TxPipeline()
+	pipe.Del(ctx, prefixedKey)
+	pipe.HMSet(ctx, prefixedKey, fields)
+	pipe.ExpireAt(ctx, prefixedKey, data.RetainUntil())
+	if len(data.Tags) > 0 {
+		tagKeys := make([]string, len(data.Tags))
+		for i := range data.Tags {
+			tagKeys[i] = rc.tagKey(data.Tags[i])
+		}
+		// PEXPIRE with ttl, that is not positive, deletes tag set with keys of other items
+		ttl := time.Until(data.RetainUntil()).Milliseconds()
+		if ttl < 1 {
+			ttl = 1
+		}
+		tagScript.Eval(ctx, pipe, tagKeys, key, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	rc.near.invalidate(prefixedKey)
 	return
 }
 
-// Get extracts item from cache
+// formatTime formats time as RFC 3339 in UTC with nanoseconds, so replicas running in different
+// time zones parse it the same way
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime parses time formatted by formatTime, or formatted as RFC 1123, like in items saved by previous versions
+func parseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Parse(time.RFC1123, value)
+	}
+	return parsed, nil
+}
+
+// Get extracts item from cache, or from its local copy, if near cache is enabled by WithNearCache
 func (rc *Cache) Get(ctx context.Context, key string) (data parent.Data, found bool, err error) {
 	key = fmt.Sprintf("%s%s", rc.prefix, key)
+	data, found = rc.near.get(key, time.Now())
+	if found {
+		return
+	}
+	ticket := rc.near.begin(key)
+	data, found, err = rc.fetch(ctx, key)
+	rc.near.put(key, data, err == nil && found, ticket)
+	return
+}
+
+// fetch extracts item with prefixed key from redis
+func (rc *Cache) fetch(ctx context.Context, key string) (data parent.Data, found bool, err error) {
 	data = parent.Data{}
 	raw, err := rc.client.HGetAll(ctx, key).Result()
 	if err != nil {
@@ -107,27 +200,226 @@ func (rc *Cache) Get(ctx context.Context, key string) (data parent.Data, found b
 	found = true
 	data.Key = raw["key"]
 	data.ContentType = raw["contentType"]
+	data.ETag = raw["etag"]
 	status, err := strconv.ParseInt(raw["status"], 10, 16)
 	if err != nil {
 		return
 	}
 	data.Status = int(status)
 	data.Body = []byte(raw["body"])
-	createdAt, err := time.Parse(time.RFC1123, raw["createdAt"])
+	data.CreatedAt, err = parseTime(raw["createdAt"])
 	if err != nil {
 		return
 	}
-	data.CreatedAt = createdAt
-	expiresAt, err := time.Parse(time.RFC1123, raw["expiresAt"])
+	data.ExpiresAt, err = parseTime(raw["expiresAt"])
 	if err != nil {
 		return
 	}
-	data.ExpiresAt = expiresAt
+	if raw["tags"] != "" {
+		err = json.Unmarshal([]byte(raw["tags"]), &data.Tags)
+		if err != nil {
+			err = fmt.Errorf("%s : while decoding tags", err)
+			return
+		}
+	}
+	if raw["staleUntil"] != "" {
+		data.StaleUntil, err = parseTime(raw["staleUntil"])
+		if err != nil {
+			return
+		}
+	}
+	if raw["staleIfErrorUntil"] != "" {
+		data.StaleIfErrorUntil, err = parseTime(raw["staleIfErrorUntil"])
+		if err != nil {
+			return
+		}
+	}
+	if raw["headers"] != "" {
+		err = json.Unmarshal([]byte(raw["headers"]), &data.Headers)
+		if err != nil {
+			err = fmt.Errorf("%s : while decoding headers", err)
+			return
+		}
+	}
 	return
 }
 
 // Delete deletes item from cache
 func (rc *Cache) Delete(ctx context.Context, key string) (err error) {
 	key = fmt.Sprintf("%s%s", rc.prefix, key)
+	rc.near.invalidate(key)
 	return rc.client.Del(ctx, key).Err()
 }
+
+// unlockScript deletes lock key only if it holds token provided, so process cannot release lock
+// acquired by other process after its own lock expired
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// lockKey returns key of lock for item. Keys of locks, tag sets and generations start with their kind,
+// not with prefix, so changes of them are not tracked for near cache.
+func (rc *Cache) lockKey(key string) string {
+	return fmt.Sprintf("lock:%s%s", rc.prefix, key)
+}
+
+// Lock acquires lock for key using SET NX PX with random token, so only one of few processes sharing
+// redis database renders response for key
+func (rc *Cache) Lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error) {
+	buf := make([]byte, 16)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return
+	}
+	token = hex.EncodeToString(buf)
+	acquired, err = rc.client.SetNX(ctx, rc.lockKey(key), token, ttl).Result()
+	return
+}
+
+// Unlock releases lock for key, if it is still held with token provided
+func (rc *Cache) Unlock(ctx context.Context, key, token string) (err error) {
+	return unlockScript.Run(ctx, rc.client, []string{rc.lockKey(key)}, token).Err()
+}
+
+// tagScript adds key to sets of keys tagged by every tag, and extends their ttl, so they live
+// at least as long as key itself
+var tagScript = redis.NewScript(`
+for _, tagKey in ipairs(KEYS) do
+	redis.call("SADD", tagKey, ARGV[1])
+	if redis.call("PTTL", tagKey) < tonumber(ARGV[2]) then
+		redis.call("PEXPIRE", tagKey, ARGV[2])
+	end
+end
+return 0
+`)
+
+func (rc *Cache) tagKey(tag string) string {
+	return fmt.Sprintf("tag:%s%s", rc.prefix, tag)
+}
+
+// invalidateScript deletes items, which keys are members of tag sets, and tag sets themselves atomically,
+// so keys added to tag sets concurrently are not lost, and returns prefixed keys of items deleted.
+// Keys of items are not declared, so it cannot be used with redis cluster.
+var invalidateScript = redis.NewScript(`
+local deleted = {}
+for _, tagKey in ipairs(KEYS) do
+	for _, key in ipairs(redis.call("SMEMBERS", tagKey)) do
+		local prefixedKey = ARGV[1] .. key
+		if redis.call("DEL", prefixedKey) == 1 then
+			table.insert(deleted, prefixedKey)
+		end
+	end
+	redis.call("DEL", tagKey)
+end
+return deleted
+`)
+
+// InvalidateTags deletes all items tagged by any of tags provided. Sets of keys tagged can contain keys,
+// that are already expired or saved again without tag, so they are deleted too.
+func (rc *Cache) InvalidateTags(ctx context.Context, tags ...string) (removed int, err error) {
+	if len(tags) == 0 {
+		return
+	}
+	tagKeys := make([]string, len(tags))
+	for i := range tags {
+		tagKeys[i] = rc.tagKey(tags[i])
+	}
+	deleted, err := invalidateScript.Run(ctx, rc.client, tagKeys, rc.prefix).StringSlice()
+	if err != nil {
+		return
+	}
+	rc.near.invalidate(deleted...)
+	return len(deleted), nil
+}
+
+// scanBatchSize is number of keys requested by every SCAN call
+const scanBatchSize = 100
+
+// escapeGlob escapes characters, that have special meaning in redis glob patterns
+func escapeGlob(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// DeletePrefix deletes all items, which keys start with prefix provided, using non-blocking SCAN and UNLINK
+func (rc *Cache) DeletePrefix(ctx context.Context, prefix string) (removed int, err error) {
+	return rc.deleteScanned(ctx, escapeGlob(rc.prefix+prefix)+"*", nil)
+}
+
+// DeleteMatching deletes all items, which keys match glob pattern provided, using non-blocking SCAN and UNLINK
+func (rc *Cache) DeleteMatching(ctx context.Context, pattern string) (removed int, err error) {
+	return rc.deleteScanned(ctx, escapeGlob(rc.prefix)+pattern, nil)
+}
+
+// DeleteRegexp deletes all items, which keys match regular expression provided. Redis cannot match keys
+// by regular expressions, so all items are scanned using non-blocking SCAN, and matched ones are unlinked.
+func (rc *Cache) DeleteRegexp(ctx context.Context, re *regexp.Regexp) (removed int, err error) {
+	return rc.deleteScanned(ctx, escapeGlob(rc.prefix)+"*", func(key string) bool {
+		return re.MatchString(strings.TrimPrefix(key, rc.prefix))
+	})
+}
+
+// deleteScanned unlinks items matching redis glob pattern and filter, if it is provided.
+// Only hashes are scanned, so locks and tag sets are kept.
+func (rc *Cache) deleteScanned(ctx context.Context, match string, filter func(key string) bool) (removed int, err error) {
+	var cursor uint64
+	var keys []string
+	for {
+		keys, cursor, err = rc.client.ScanType(ctx, cursor, match, scanBatchSize, "hash").Result()
+		if err != nil {
+			return
+		}
+		if filter != nil {
+			filtered := keys[:0]
+			for _, key := range keys {
+				if filter(key) {
+					filtered = append(filtered, key)
+				}
+			}
+			keys = filtered
+		}
+		if len(keys) > 0 {
+			rc.near.invalidate(keys...)
+			unlinked, errU := rc.client.Unlink(ctx, keys...).Result()
+			if errU != nil {
+				return removed, errU
+			}
+			removed += int(unlinked)
+		}
+		if cursor == 0 {
+			return
+		}
+	}
+}
+
+func (rc *Cache) generationKey(namespace string) string {
+	return fmt.Sprintf("generation:%s%s", rc.prefix, namespace)
+}
+
+// Generation returns current generation of namespace
+func (rc *Cache) Generation(ctx context.Context, namespace string) (generation uint64, err error) {
+	generation, err = rc.client.Get(ctx, rc.generationKey(namespace)).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return
+}
+
+// BumpGeneration increments generation of namespace using INCR, so all items cached in it are invalidated
+func (rc *Cache) BumpGeneration(ctx context.Context, namespace string) (generation uint64, err error) {
+	incremented, err := rc.client.Incr(ctx, rc.generationKey(namespace)).Result()
+	if err != nil {
+		return
+	}
+	return uint64(incremented), nil
+}
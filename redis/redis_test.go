@@ -3,10 +3,12 @@ package rcache
 import (
 	"context"
 	"net/http"
+	"regexp"
 	"testing"
 	"time"
 
 	parent "github.com/vodolaz095/gin-cache"
+	"github.com/vodolaz095/gin-cache/memory"
 )
 
 var testMemoryStore *Cache
@@ -26,6 +28,8 @@ func TestCache_Save(t *testing.T) {
 		Body:        []byte("this is body of a key"),
 		Status:      http.StatusTeapot,
 		ContentType: "text/plain",
+		Headers:     http.Header{"X-Powered-By": []string{"gin"}},
+		Tags:        []string{"letters"},
 		CreatedAt:   time.Now(),
 		ExpiresAt:   time.Now().Add(time.Second),
 	})
@@ -58,6 +62,63 @@ func TestCache_Get(t *testing.T) {
 	if string(hit.Body) != "this is body of a key" {
 		t.Error("wrongly saved?")
 	}
+	if hit.Headers.Get("X-Powered-By") != "gin" {
+		t.Error("headers wrongly saved?")
+	}
+	if len(hit.Tags) != 1 || hit.Tags[0] != "letters" {
+		t.Error("tags wrongly saved?")
+	}
+}
+
+func TestCache_StaleWindows(t *testing.T) {
+	// replicas can run in different time zones
+	zone := time.FixedZone("MSK", 3*60*60)
+	now := time.Now().In(zone)
+	data := parent.Data{
+		Body:              []byte("this is body of stale key"),
+		Status:            http.StatusOK,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(time.Second),
+		StaleUntil:        now.Add(time.Minute),
+		StaleIfErrorUntil: now.Add(2 * time.Minute),
+	}
+	err := testMemoryStore.Save(testContext, "stale", data)
+	if err != nil {
+		t.Error(err)
+	}
+	hit, found, err := testMemoryStore.Get(testContext, "stale")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("key is not found?")
+	}
+	for name, times := range map[string][2]time.Time{
+		"created at":           {hit.CreatedAt, data.CreatedAt},
+		"expires at":           {hit.ExpiresAt, data.ExpiresAt},
+		"stale until":          {hit.StaleUntil, data.StaleUntil},
+		"stale if error until": {hit.StaleIfErrorUntil, data.StaleIfErrorUntil},
+	} {
+		if !times[0].Equal(times[1]) {
+			t.Errorf("wrong %s %s instead of %s", name, times[0], times[1])
+		}
+	}
+	// items saved by previous versions have RFC 1123 timestamps
+	now = time.Now().UTC()
+	err = testMemoryStore.client.HSet(testContext, testMemoryStore.prefix+"stale",
+		"createdAt", now.Format(time.RFC1123),
+		"expiresAt", now.Add(time.Second).Format(time.RFC1123),
+	).Err()
+	if err != nil {
+		t.Error(err)
+	}
+	hit, _, err = testMemoryStore.Get(testContext, "stale")
+	if err != nil {
+		t.Errorf("%s : while parsing RFC 1123 timestamps", err)
+	}
+	if hit.CreatedAt.Unix() != now.Unix() {
+		t.Errorf("wrong created at %s instead of %s", hit.CreatedAt, now)
+	}
 }
 
 func TestCache_Delete(t *testing.T) {
@@ -105,3 +166,343 @@ func TestExpires(t *testing.T) {
 		t.Error("deleted key is found?")
 	}
 }
+
+func TestCache_Lock(t *testing.T) {
+	token, acquired, err := testMemoryStore.Lock(testContext, "c", time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if !acquired {
+		t.Error("lock is not acquired")
+	}
+	_, acquired, err = testMemoryStore.Lock(testContext, "c", time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if acquired {
+		t.Error("lock is acquired twice")
+	}
+	err = testMemoryStore.Unlock(testContext, "c", "wrong token")
+	if err != nil {
+		t.Error(err)
+	}
+	_, acquired, err = testMemoryStore.Lock(testContext, "c", time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if acquired {
+		t.Error("lock is released with wrong token")
+	}
+	err = testMemoryStore.Unlock(testContext, "c", token)
+	if err != nil {
+		t.Error(err)
+	}
+	token, acquired, err = testMemoryStore.Lock(testContext, "c", time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if !acquired {
+		t.Error("lock is not released")
+	}
+	err = testMemoryStore.Unlock(testContext, "c", token)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCache_InvalidateTags(t *testing.T) {
+	for key, tags := range map[string][]string{
+		"product1": {"product:1", "category:1"},
+		"product2": {"product:2", "category:1"},
+		"product3": {"product:3", "category:2"},
+	} {
+		err := testMemoryStore.Save(testContext, key, parent.Data{
+			Body:      []byte("this is body of " + key),
+			Status:    http.StatusOK,
+			Tags:      tags,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	removed, err := testMemoryStore.InvalidateTags(testContext, "category:1", "product:2")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 2 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	for _, key := range []string{"product1", "product2"} {
+		_, found, err := testMemoryStore.Get(testContext, key)
+		if err != nil {
+			t.Error(err)
+		}
+		if found {
+			t.Errorf("%s is not invalidated", key)
+		}
+	}
+	_, found, err := testMemoryStore.Get(testContext, "product3")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Error("product3 is invalidated")
+	}
+	// tag sets of items, that are already expired, are not kept forever
+	err = testMemoryStore.Save(testContext, "expired", parent.Data{
+		Body:      []byte("this is body of expired"),
+		Status:    http.StatusOK,
+		Tags:      []string{"expired:1"},
+		CreatedAt: time.Now().Add(-time.Hour),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	ttl, err := testMemoryStore.client.PTTL(testContext, testMemoryStore.tagKey("expired:1")).Result()
+	if err != nil {
+		t.Error(err)
+	}
+	if ttl == -1 {
+		t.Error("tag set of expired item has no ttl")
+	}
+	err = testMemoryStore.Delete(testContext, "product3")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCache_DeletePrefix(t *testing.T) {
+	for _, key := range []string{"/catalog/1", "/catalog/2/reviews", "/catalogue", "/posts/1"} {
+		err := testMemoryStore.Save(testContext, key, parent.Data{
+			Body:      []byte("this is body of " + key),
+			Status:    http.StatusOK,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	removed, err := testMemoryStore.DeletePrefix(testContext, "/catalog/")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 2 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	removed, err = testMemoryStore.DeleteRegexp(testContext, regexp.MustCompile(`^/catalog(ue)?$`))
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+	removed, err = testMemoryStore.DeleteMatching(testContext, "/[cp]*")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Errorf("wrong number of removed keys %v", removed)
+	}
+}
+
+func TestCache_Generations(t *testing.T) {
+	initial, err := testMemoryStore.Generation(testContext, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	generation, err := testMemoryStore.BumpGeneration(testContext, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != initial+1 {
+		t.Errorf("wrong bumped generation %v", generation)
+	}
+	generation, err = testMemoryStore.Generation(testContext, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != initial+1 {
+		t.Errorf("wrong generation %v", generation)
+	}
+}
+
+func TestBus(t *testing.T) {
+	publisher, err := NewBus(DefaultConnectionString, "HolyMeatBus")
+	if err != nil {
+		t.Fatalf("%s : while dialing redis", err)
+	}
+	defer publisher.Close()
+	subscriber, err := NewBus(DefaultConnectionString, "HolyMeatBus")
+	if err != nil {
+		t.Fatalf("%s : while dialing redis", err)
+	}
+	defer subscriber.Close()
+	local := memory.New(time.Minute)
+	for _, key := range []string{"/posts/1", "/posts/2", "/posts/3"} {
+		err = local.Save(testContext, key, parent.Data{
+			Body:      []byte("this is body of " + key),
+			Status:    http.StatusOK,
+			Tags:      []string{key},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(testContext)
+	defer cancel()
+	go subscriber.Subscribe(ctx, local, func(event Event, err error) {
+		t.Errorf("%s : while applying %s event", err, event.Type)
+	})
+	time.Sleep(100 * time.Millisecond)
+	found := func(key string) bool {
+		_, found, errG := local.Get(testContext, key)
+		if errG != nil {
+			t.Error(errG)
+		}
+		return found
+	}
+	eventually := func(condition func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if condition() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	err = publisher.PublishDelete(testContext, "/posts/1")
+	if err != nil {
+		t.Error(err)
+	}
+	if !eventually(func() bool { return !found("/posts/1") }) {
+		t.Error("key is not deleted by event")
+	}
+	err = publisher.PublishTags(testContext, "/posts/2")
+	if err != nil {
+		t.Error(err)
+	}
+	if !eventually(func() bool { return !found("/posts/2") }) {
+		t.Error("tag is not invalidated by event")
+	}
+	err = subscriber.PublishFlush(testContext)
+	if err != nil {
+		t.Error(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !found("/posts/3") {
+		t.Error("event published by subscriber itself is applied")
+	}
+	err = publisher.PublishFlush(testContext)
+	if err != nil {
+		t.Error(err)
+	}
+	if !eventually(func() bool { return !found("/posts/3") }) {
+		t.Error("cache is not flushed by event")
+	}
+}
+
+func TestNearCache_Eviction(t *testing.T) {
+	near := newNearCache(2)
+	near.tracking = true
+	put := func(key string) {
+		near.put(key, parent.Data{Key: key, ExpiresAt: time.Now().Add(time.Minute)}, true, near.begin(key))
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		put(key)
+		near.get("a", time.Now())
+	}
+	if _, found := near.get("b", time.Now()); found {
+		t.Error("least recently used item is not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, found := near.get(key, time.Now()); !found {
+			t.Errorf("item %s is evicted", key)
+		}
+	}
+	ticket := near.begin("a")
+	near.invalidate("a")
+	if _, found := near.get("a", time.Now()); found {
+		t.Error("item is not invalidated")
+	}
+	near.put("a", parent.Data{Key: "a", ExpiresAt: time.Now().Add(time.Minute)}, true, ticket)
+	if _, found := near.get("a", time.Now()); found {
+		t.Error("item read before invalidation is saved")
+	}
+	// invalidations of other items do not prevent item from being saved
+	ticket = near.begin("b")
+	near.invalidate("a", "lock:b")
+	near.put("b", parent.Data{Key: "b", ExpiresAt: time.Now().Add(time.Minute)}, true, ticket)
+	if _, found := near.get("b", time.Now()); !found {
+		t.Error("item is not saved after invalidation of other items")
+	}
+	if len(near.fetches) != 0 {
+		t.Errorf("completed reads are not forgotten: %v", near.fetches)
+	}
+	if _, found := near.get("c", time.Now().Add(time.Hour)); found {
+		t.Error("expired item is found")
+	}
+	// local copies are not kept, while items are not tracked
+	near.Lock()
+	near.tracking = false
+	near.purge()
+	near.Unlock()
+	put("c")
+	if _, found := near.get("c", time.Now()); found {
+		t.Error("item is saved, while it is not tracked")
+	}
+}
+
+func TestCache_NearCache(t *testing.T) {
+	near, err := New(DefaultConnectionString, "HolyMeatNear", WithNearCache(10))
+	if err != nil {
+		t.Fatalf("%s : while dialing redis", err)
+	}
+	defer near.Close()
+	writer, err := New(DefaultConnectionString, "HolyMeatNear")
+	if err != nil {
+		t.Fatalf("%s : while dialing redis", err)
+	}
+	defer writer.Close()
+	save := func(body string) {
+		errS := writer.Save(testContext, "a", parent.Data{
+			Body:      []byte(body),
+			Status:    http.StatusOK,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		if errS != nil {
+			t.Error(errS)
+		}
+	}
+	get := func() string {
+		data, found, errG := near.Get(testContext, "a")
+		if errG != nil {
+			t.Error(errG)
+		}
+		if !found {
+			t.Error("item is not found")
+		}
+		return string(data.Body)
+	}
+	save("first")
+	if body := get(); body != "first" {
+		t.Errorf("wrong body %s", body)
+	}
+	if _, found := near.near.get("HolyMeatNear"+"a", time.Now()); !found {
+		t.Error("item is not copied to near cache")
+	}
+	save("second")
+	for i := 0; i < 100; i++ {
+		if get() == "second" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("near cache is not invalidated")
+}
@@ -0,0 +1,66 @@
+package gincache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// revalidationKey marks context of request replayed by middleware to refresh stale response
+type revalidationKey struct{}
+
+func isRevalidation(c *gin.Context) bool {
+	return c.Request.Context().Value(revalidationKey{}) != nil
+}
+
+// discardWriter is response writer for replayed requests, it discards everything written
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discardWriter) WriteHeader(int) {}
+
+// revalidationTimeout is maximum duration of replaying request to refresh stale response, tests shorten it
+var revalidationTimeout = 30 * time.Second
+
+// revalidate replays request against gin engine in background, so stale response for key is rendered
+// and saved again. Only one revalidation per key is performed by middleware at the same time. If handler
+// does not complete until revalidationTimeout, key can be revalidated again.
+func (m *middleware) revalidate(c *gin.Context, key string) {
+	f, leader := m.revalidations.join(key)
+	if !leader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), revalidationKey{}, true), revalidationTimeout)
+	req := c.Request.Clone(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(gin.DefaultErrorWriter, "[GIN-CACHE] %v : while revalidating %s\n", r, key)
+			}
+		}()
+		m.engine.ServeHTTP(&discardWriter{header: make(http.Header)}, req)
+	}()
+	go func() {
+		defer cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			fmt.Fprintf(gin.DefaultErrorWriter, "[GIN-CACHE] %s : while revalidating %s\n", ctx.Err(), key)
+		}
+		m.revalidations.land(key, f)
+	}()
+}
@@ -0,0 +1,81 @@
+package gincache
+
+import (
+	"net/http"
+	"time"
+)
+
+// StatusPolicy decides, if response with status code provided can be cached, and for how long.
+// It receives ttl returned by key extractor function and returns ttl to be used for response.
+// Responses with 5xx status codes are never cached, whatever policy returns.
+type StatusPolicy func(status int, ttl time.Duration) (newTTL time.Duration, cacheable bool)
+
+// DefaultCacheableStatusCodes are status codes of successful responses, that are cached by default
+var DefaultCacheableStatusCodes = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+}
+
+// HeuristicCacheableStatusCodes are status codes of responses, that are cacheable by default according
+// to RFC 7231 section 6.1 and RFC 7538, except server errors. Redirects and client errors among them are
+// cached by HeuristicStatusPolicy with their own ttl.
+var HeuristicCacheableStatusCodes = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusMultipleChoices,
+	http.StatusMovedPermanently,
+	http.StatusPermanentRedirect,
+	http.StatusNotFound,
+	http.StatusMethodNotAllowed,
+	http.StatusGone,
+	http.StatusRequestURITooLong,
+}
+
+// DefaultStatusPolicy caches responses with DefaultCacheableStatusCodes using ttl returned by key extractor
+func DefaultStatusPolicy(status int, ttl time.Duration) (time.Duration, bool) {
+	return StatusCodes(DefaultCacheableStatusCodes...)(status, ttl)
+}
+
+// HeuristicStatusPolicy returns policy, that caches responses with HeuristicCacheableStatusCodes - successful
+// ones using ttl returned by key extractor, and redirects and client errors, like 404 Not Found, using ttl provided.
+// Zero ttl means ttl returned by key extractor is used for all of them.
+func HeuristicStatusPolicy(ttl time.Duration) StatusPolicy {
+	ttls := make(map[int]time.Duration, len(HeuristicCacheableStatusCodes))
+	for _, status := range HeuristicCacheableStatusCodes {
+		ttls[status] = ttl
+	}
+	for _, status := range DefaultCacheableStatusCodes {
+		ttls[status] = 0
+	}
+	return StatusTTL(ttls)
+}
+
+// StatusCodes returns policy, that caches only responses with status codes provided,
+// using ttl returned by key extractor
+func StatusCodes(codes ...int) StatusPolicy {
+	return func(status int, ttl time.Duration) (time.Duration, bool) {
+		for i := range codes {
+			if codes[i] == status {
+				return ttl, true
+			}
+		}
+		return 0, false
+	}
+}
+
+// StatusTTL returns policy, that caches only responses with status codes present in map,
+// using ttl from map. Zero ttl in map means ttl returned by key extractor is used.
+func StatusTTL(ttls map[int]time.Duration) StatusPolicy {
+	return func(status int, ttl time.Duration) (time.Duration, bool) {
+		statusTTL, found := ttls[status]
+		if !found {
+			return 0, false
+		}
+		if statusTTL == 0 {
+			return ttl, true
+		}
+		return statusTTL, true
+	}
+}
@@ -0,0 +1,4 @@
+// Package tiered implements two-tier near cache, that keeps copies of responses stored in shared caching backend,
+// like redis one, in fast local one, like memory one, for limited time, so most of requests are served without
+// network round trips, while few webserver processes still share same cache.
+package tiered
@@ -0,0 +1,234 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	parent "github.com/vodolaz095/gin-cache"
+)
+
+// Cache is two-tier caching engine, that reads responses from L1 tier first, falls back to L2 tier,
+// and copies responses found in L2 tier to L1 one
+type Cache struct {
+	l1     parent.Cache
+	l2     parent.Cache
+	l1TTL  time.Duration
+	l1Hits atomic.Uint64
+	l2Hits atomic.Uint64
+	misses atomic.Uint64
+	// generations are copies of namespace generations read from L2 tier, kept no longer than l1TTL
+	generations     map[string]generation
+	generationsLock sync.Mutex
+}
+
+// generation is copy of namespace generation read from L2 tier
+type generation struct {
+	value uint64
+	until time.Time
+}
+
+// Stats is number of responses found in every tier, and not found in any of them
+type Stats struct {
+	L1Hits uint64 `json:"l1Hits"`
+	L2Hits uint64 `json:"l2Hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// New creates two-tier caching driver with local L1 tier, like memory.Cache, and shared L2 tier, like redis one.
+// Responses are kept in L1 tier no longer than l1TTL, zero l1TTL means they are kept as long as in L2 tier.
+// Responses served from L1 tier have their expiration capped by l1TTL too.
+func New(l1, l2 parent.Cache, l1TTL time.Duration) *Cache {
+	return &Cache{l1: l1, l2: l2, l1TTL: l1TTL, generations: make(map[string]generation)}
+}
+
+// Save saves item in both tiers
+func (t *Cache) Save(ctx context.Context, key string, data parent.Data) (err error) {
+	err = t.l2.Save(ctx, key, data)
+	if err != nil {
+		return
+	}
+	return t.l1.Save(ctx, key, t.capped(data, time.Now()))
+}
+
+// Get extracts item from L1 tier, or from L2 tier, if it is not found in L1 one
+func (t *Cache) Get(ctx context.Context, key string) (data parent.Data, found bool, err error) {
+	now := time.Now()
+	data, found, err = t.l1.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	if found && (data.RetainUntil().IsZero() || now.Before(data.RetainUntil())) {
+		t.l1Hits.Add(1)
+		return
+	}
+	data, found, err = t.l2.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	if !found {
+		t.misses.Add(1)
+		return
+	}
+	t.l2Hits.Add(1)
+	// item found in L2 tier is served anyway, if it cannot be copied to L1 one
+	_ = t.l1.Save(ctx, key, t.capped(data, now))
+	return data, true, nil
+}
+
+// Delete deletes item from both tiers
+func (t *Cache) Delete(ctx context.Context, key string) (err error) {
+	return errors.Join(t.l2.Delete(ctx, key), t.l1.Delete(ctx, key))
+}
+
+// InvalidateTags deletes items tagged by any of tags provided from both tiers, and returns number of items
+// deleted from L2 tier. Both tiers should implement parent.TagInvalidator.
+func (t *Cache) InvalidateTags(ctx context.Context, tags ...string) (removed int, err error) {
+	l1, ok1 := t.l1.(parent.TagInvalidator)
+	l2, ok2 := t.l2.(parent.TagInvalidator)
+	if !ok1 || !ok2 {
+		return 0, parent.ErrUnsupported
+	}
+	removed, err = l2.InvalidateTags(ctx, tags...)
+	_, errL1 := l1.InvalidateTags(ctx, tags...)
+	return removed, errors.Join(err, errL1)
+}
+
+// DeletePrefix deletes items, which keys start with prefix provided, from both tiers, and returns number of
+// items deleted from L2 tier. Both tiers should implement parent.PrefixDeleter.
+func (t *Cache) DeletePrefix(ctx context.Context, prefix string) (removed int, err error) {
+	l1, ok1 := t.l1.(parent.PrefixDeleter)
+	l2, ok2 := t.l2.(parent.PrefixDeleter)
+	if !ok1 || !ok2 {
+		return 0, parent.ErrUnsupported
+	}
+	removed, err = l2.DeletePrefix(ctx, prefix)
+	_, errL1 := l1.DeletePrefix(ctx, prefix)
+	return removed, errors.Join(err, errL1)
+}
+
+// DeleteMatching deletes items, which keys match glob pattern provided, from both tiers, and returns number of
+// items deleted from L2 tier. Both tiers should implement parent.PrefixDeleter.
+func (t *Cache) DeleteMatching(ctx context.Context, pattern string) (removed int, err error) {
+	l1, ok1 := t.l1.(parent.PrefixDeleter)
+	l2, ok2 := t.l2.(parent.PrefixDeleter)
+	if !ok1 || !ok2 {
+		return 0, parent.ErrUnsupported
+	}
+	removed, err = l2.DeleteMatching(ctx, pattern)
+	_, errL1 := l1.DeleteMatching(ctx, pattern)
+	return removed, errors.Join(err, errL1)
+}
+
+// DeleteRegexp deletes items, which keys match regular expression provided, from both tiers, and returns number
+// of items deleted from L2 tier. Both tiers should implement parent.RegexpDeleter.
+func (t *Cache) DeleteRegexp(ctx context.Context, re *regexp.Regexp) (removed int, err error) {
+	l1, ok1 := t.l1.(parent.RegexpDeleter)
+	l2, ok2 := t.l2.(parent.RegexpDeleter)
+	if !ok1 || !ok2 {
+		return 0, parent.ErrUnsupported
+	}
+	removed, err = l2.DeleteRegexp(ctx, re)
+	_, errL1 := l1.DeleteRegexp(ctx, re)
+	return removed, errors.Join(err, errL1)
+}
+
+// Lock acquires lock for key in L2 tier, that is shared between processes. L2 tier should implement parent.Locker.
+func (t *Cache) Lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error) {
+	locker, ok := t.l2.(parent.Locker)
+	if !ok {
+		return "", false, parent.ErrUnsupported
+	}
+	return locker.Lock(ctx, key, ttl)
+}
+
+// Unlock releases lock for key in L2 tier. L2 tier should implement parent.Locker.
+func (t *Cache) Unlock(ctx context.Context, key, token string) (err error) {
+	locker, ok := t.l2.(parent.Locker)
+	if !ok {
+		return parent.ErrUnsupported
+	}
+	return locker.Unlock(ctx, key, token)
+}
+
+// Generation returns current generation of namespace stored in L2 tier, that is shared between processes.
+// It is kept in process memory no longer than l1TTL, like items in L1 tier, so generation bumped by other
+// process is applied after l1TTL is over, and zero l1TTL means L2 tier is read every time.
+// L2 tier should implement parent.Generations.
+func (t *Cache) Generation(ctx context.Context, namespace string) (value uint64, err error) {
+	generations, ok := t.l2.(parent.Generations)
+	if !ok {
+		return 0, parent.ErrUnsupported
+	}
+	now := time.Now()
+	t.generationsLock.Lock()
+	cached, found := t.generations[namespace]
+	t.generationsLock.Unlock()
+	if found && now.Before(cached.until) {
+		return cached.value, nil
+	}
+	value, err = generations.Generation(ctx, namespace)
+	if err != nil {
+		return
+	}
+	t.remember(namespace, value, now)
+	return value, nil
+}
+
+// BumpGeneration increments generation of namespace stored in L2 tier, so all items cached in it are
+// invalidated in both tiers, because their keys include generation. L2 tier should implement parent.Generations.
+func (t *Cache) BumpGeneration(ctx context.Context, namespace string) (value uint64, err error) {
+	generations, ok := t.l2.(parent.Generations)
+	if !ok {
+		return 0, parent.ErrUnsupported
+	}
+	value, err = generations.BumpGeneration(ctx, namespace)
+	if err != nil {
+		return
+	}
+	t.remember(namespace, value, time.Now())
+	return value, nil
+}
+
+// remember keeps copy of namespace generation for l1TTL
+func (t *Cache) remember(namespace string, value uint64, now time.Time) {
+	if t.l1TTL <= 0 {
+		return
+	}
+	t.generationsLock.Lock()
+	defer t.generationsLock.Unlock()
+	// generation read concurrently with bumping it can be older
+	if cached, found := t.generations[namespace]; found && cached.value > value && now.Before(cached.until) {
+		return
+	}
+	t.generations[namespace] = generation{value: value, until: now.Add(t.l1TTL)}
+}
+
+// Stats returns number of items found in every tier, and not found in any of them, since cache was created
+func (t *Cache) Stats() Stats {
+	return Stats{
+		L1Hits: t.l1Hits.Load(),
+		L2Hits: t.l2Hits.Load(),
+		Misses: t.misses.Load(),
+	}
+}
+
+// capped returns copy of item to be saved in L1 tier, which expiration is capped by l1TTL
+func (t *Cache) capped(data parent.Data, now time.Time) parent.Data {
+	if t.l1TTL <= 0 {
+		return data
+	}
+	deadline := now.Add(t.l1TTL)
+	if data.ExpiresAt.IsZero() {
+		data.ExpiresAt = deadline
+	}
+	for _, moment := range []*time.Time{&data.ExpiresAt, &data.StaleUntil, &data.StaleIfErrorUntil} {
+		if moment.After(deadline) {
+			*moment = deadline
+		}
+	}
+	return data
+}
@@ -0,0 +1,188 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	parent "github.com/vodolaz095/gin-cache"
+	"github.com/vodolaz095/gin-cache/memory"
+)
+
+var ctx = context.TODO()
+
+func TestCache(t *testing.T) {
+	l1 := memory.New(time.Second)
+	l2 := memory.New(time.Second)
+	cache := New(l1, l2, 100*time.Millisecond)
+	err := cache.Save(ctx, "a", parent.Data{
+		Body:      []byte("this is body of a key"),
+		Status:    http.StatusOK,
+		Tags:      []string{"letters"},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	data, found, err := l1.Get(ctx, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("item is not saved in L1 tier")
+	}
+	if time.Until(data.ExpiresAt) > 100*time.Millisecond {
+		t.Errorf("L1 tier expiration is not capped: %s", data.ExpiresAt)
+	}
+	data, found, err = l2.Get(ctx, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("item is not saved in L2 tier")
+	}
+	if time.Until(data.ExpiresAt) < 50*time.Second {
+		t.Errorf("L2 tier expiration is capped: %s", data.ExpiresAt)
+	}
+
+	_, found, err = cache.Get(ctx, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Error("item is not found")
+	}
+	time.Sleep(150 * time.Millisecond)
+	data, found, err = cache.Get(ctx, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Error("item is not found in L2 tier")
+	}
+	if string(data.Body) != "this is body of a key" {
+		t.Errorf("wrong body %s", data.Body)
+	}
+	_, found, err = cache.Get(ctx, "a")
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Error("item is not copied to L1 tier")
+	}
+	_, found, err = cache.Get(ctx, "b")
+	if err != nil {
+		t.Error(err)
+	}
+	if found {
+		t.Error("unexpected item found")
+	}
+	stats := cache.Stats()
+	if stats.L1Hits != 2 || stats.L2Hits != 1 || stats.Misses != 1 {
+		t.Errorf("wrong stats %+v", stats)
+	}
+
+	removed, err := cache.InvalidateTags(ctx, "letters")
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Errorf("wrong number of removed items %v", removed)
+	}
+	for name, tier := range map[string]parent.Cache{"L1": l1, "L2": l2} {
+		_, found, err = tier.Get(ctx, "a")
+		if err != nil {
+			t.Error(err)
+		}
+		if found {
+			t.Errorf("item is not deleted from %s tier", name)
+		}
+	}
+}
+
+// failingCache is caching backend, that cannot save items
+type failingCache struct {
+	*memory.Cache
+}
+
+func (f failingCache) Save(ctx context.Context, key string, data parent.Data) error {
+	return errors.New("cache is full")
+}
+
+func TestCapabilities(t *testing.T) {
+	var _ parent.Locker = &Cache{}
+	var _ parent.Generations = &Cache{}
+	var _ parent.RegexpDeleter = &Cache{}
+
+	l1 := memory.New(time.Second)
+	l2 := memory.New(time.Second)
+	cache := New(l1, l2, time.Minute)
+	generation, err := cache.BumpGeneration(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	stored, err := l2.Generation(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != 1 || stored != 1 {
+		t.Errorf("generation is not bumped in L2 tier: %v %v", generation, stored)
+	}
+	// generation is read from L2 tier once per l1TTL
+	_, err = l2.BumpGeneration(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	generation, err = cache.Generation(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != 1 {
+		t.Errorf("generation is read from L2 tier before l1TTL is over: %v", generation)
+	}
+	generation, err = New(l1, l2, 0).Generation(ctx, "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if generation != 2 {
+		t.Errorf("generation is not read from L2 tier with zero l1TTL: %v", generation)
+	}
+	_, _, err = cache.Lock(ctx, "a", time.Second)
+	if !errors.Is(err, parent.ErrUnsupported) {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = cache.Save(ctx, "/posts/1", parent.Data{Body: []byte("post"), ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Error(err)
+	}
+	removed, err := cache.DeleteRegexp(ctx, regexp.MustCompile(`^/posts/[0-9]+$`))
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Errorf("wrong number of removed items %v", removed)
+	}
+	if _, found, _ := l1.Get(ctx, "/posts/1"); found {
+		t.Error("item is not deleted from L1 tier")
+	}
+}
+
+func TestL1SaveFailure(t *testing.T) {
+	l2 := memory.New(time.Second)
+	cache := New(failingCache{memory.New(time.Second)}, l2, time.Minute)
+	err := l2.Save(ctx, "a", parent.Data{Body: []byte("a"), ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Error(err)
+	}
+	data, found, err := cache.Get(ctx, "a")
+	if err != nil {
+		t.Errorf("L1 tier error is returned: %s", err)
+	}
+	if !found || string(data.Body) != "a" {
+		t.Errorf("item from L2 tier is not served: %v %s", found, data.Body)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -67,7 +70,7 @@ func TestPrepare(t *testing.T) {
 	cacherMiddleware := New(testCache, CacheByPath(time.Second))
 	testApp.Use(cacherMiddleware)
 	testApp.NoRoute(func(c *gin.Context) {
-		c.String(http.StatusTeapot, "Current time is %s", time.Now().Format(time.Stamp))
+		c.String(http.StatusOK, "Current time is %s", time.Now().Format(time.Stamp))
 	})
 }
 
@@ -184,3 +187,1244 @@ func TestPostCached(t *testing.T) {
 		t.Error("cache should be bypassed for POST")
 	}
 }
+
+type failingCacher struct{}
+
+func (f failingCacher) Save(ctx context.Context, key string, data Data) (err error) {
+	return fmt.Errorf("save failed")
+}
+
+func (f failingCacher) Get(ctx context.Context, key string) (data Data, found bool, err error) {
+	return data, false, fmt.Errorf("get failed")
+}
+
+func (f failingCacher) Delete(ctx context.Context, key string) (err error) {
+	return fmt.Errorf("delete failed")
+}
+
+func TestFailOpen(t *testing.T) {
+	phases := make([]Phase, 0)
+	app := gin.New()
+	app.Use(NewWithOptions(failingCacher{},
+		WithKeyExtractor(CacheByPath(time.Second)),
+		FailOpen(),
+		OnError(func(c *gin.Context, phase Phase, err error) {
+			t.Logf("Error %s in phase %s", err, phase)
+			phases = append(phases, phase)
+		}),
+	))
+	app.GET("/time", func(c *gin.Context) {
+		c.String(http.StatusOK, "Current time is %s", time.Now().Format(time.Stamp))
+	})
+	req := httptest.NewRequest("GET", "http://localhost/time", nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("wrong status %v", w.Code)
+	}
+	if len(phases) != 1 {
+		t.Errorf("wrong number of errors reported: %v", len(phases))
+		return
+	}
+	if phases[0] != PhaseGet {
+		t.Errorf("wrong phase %s", phases[0])
+	}
+}
+
+func TestFailClosed(t *testing.T) {
+	app := gin.New()
+	app.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	}))
+	app.Use(New(failingCacher{}, CacheByPath(time.Second)))
+	app.GET("/time", func(c *gin.Context) {
+		c.String(http.StatusOK, "Current time is %s", time.Now().Format(time.Stamp))
+	})
+	req := httptest.NewRequest("GET", "http://localhost/time", nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("middleware should panic without fail open mode, got status %v", w.Code)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	var hits, misses, saves int
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithMethods(http.MethodGet, http.MethodPost),
+		WithStatusCodes(http.StatusOK),
+		WithMaxBodySize(10),
+		WithExpirationHeaders(false),
+		OnHit(func(c *gin.Context, data Data) { hits++ }),
+		OnMiss(func(c *gin.Context, key string) { misses++ }),
+		OnSave(func(c *gin.Context, data Data) { saves++ }),
+	))
+	app.Any("/short", func(c *gin.Context) {
+		c.String(http.StatusOK, "short")
+	})
+	app.GET("/long", func(c *gin.Context) {
+		c.String(http.StatusOK, "this body is too long to be cached")
+	})
+	app.GET("/missing", func(c *gin.Context) {
+		c.String(http.StatusNotFound, "missing")
+	})
+	for _, target := range []string{"/short", "/short", "/long", "/missing"} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Header().Get("Expires") != "" {
+			t.Error("expiration headers are set?")
+		}
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/short", nil))
+	if w.Body.String() != "short" {
+		t.Error("wrong body for POST")
+	}
+	if hits != 2 || misses != 3 || saves != 1 {
+		t.Errorf("wrong hooks calls: hits %v, misses %v, saves %v", hits, misses, saves)
+	}
+	if len(cache.items) != 1 {
+		t.Errorf("wrong number of items in cache: %v", len(cache.items))
+	}
+}
+
+func TestStatusPolicy(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithStatusTTL(map[int]time.Duration{
+			http.StatusOK:                  0,
+			http.StatusNotFound:            time.Second,
+			http.StatusInternalServerError: time.Hour,
+		}),
+	))
+	app.GET("/ok", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	app.GET("/missing", func(c *gin.Context) {
+		c.String(http.StatusNotFound, "missing")
+	})
+	app.GET("/teapot", func(c *gin.Context) {
+		c.String(http.StatusTeapot, "teapot")
+	})
+	app.GET("/error", func(c *gin.Context) {
+		c.String(http.StatusInternalServerError, "error")
+	})
+	for _, target := range []string{"/ok", "/missing", "/teapot", "/error"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	if len(cache.items) != 2 {
+		t.Errorf("wrong number of items in cache: %v", len(cache.items))
+	}
+	ok, found := cache.items["/ok"]
+	if !found {
+		t.Error("/ok is not cached")
+	} else if ok.ExpiresAt.Sub(ok.CreatedAt) != time.Minute {
+		t.Errorf("wrong ttl for /ok: %s", ok.ExpiresAt.Sub(ok.CreatedAt))
+	}
+	missing, found := cache.items["/missing"]
+	if !found {
+		t.Error("/missing is not cached")
+	} else if missing.ExpiresAt.Sub(missing.CreatedAt) != time.Second {
+		t.Errorf("wrong ttl for /missing: %s", missing.ExpiresAt.Sub(missing.CreatedAt))
+	}
+}
+
+func TestDefaultStatusPolicy(t *testing.T) {
+	for policy, expected := range map[string]map[int]time.Duration{
+		"default": {
+			http.StatusOK:               time.Minute,
+			http.StatusNoContent:        time.Minute,
+			http.StatusMovedPermanently: 0,
+			http.StatusNotFound:         0,
+		},
+		"heuristic": {
+			http.StatusOK:               time.Minute,
+			http.StatusNoContent:        time.Minute,
+			http.StatusMovedPermanently: time.Second,
+			http.StatusNotFound:         time.Second,
+			http.StatusTeapot:           0,
+		},
+	} {
+		statusPolicy := DefaultStatusPolicy
+		if policy == "heuristic" {
+			statusPolicy = HeuristicStatusPolicy(time.Second)
+		}
+		for status, expectedTTL := range expected {
+			ttl, cacheable := statusPolicy(status, time.Minute)
+			if cacheable != (expectedTTL > 0) || (cacheable && ttl != expectedTTL) {
+				t.Errorf("%s policy returns ttl %s and %v for status %v", policy, ttl, cacheable, status)
+			}
+		}
+	}
+}
+
+func TestHeadersReplayed(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	var requests int
+	// headers set by middleware executed before cache are different for every request
+	app.Use(func(c *gin.Context) {
+		requests++
+		c.Header("X-Request-Id", strconv.Itoa(requests))
+		c.Header("Access-Control-Allow-Origin", c.GetHeader("Origin"))
+		c.Header("Vary", "Origin")
+	})
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithHeaderDenyList("X-Debug"),
+	))
+	app.GET("/headers", func(c *gin.Context) {
+		c.Header("Cache-Control", "public")
+		c.Header("Vary", "Accept-Encoding")
+		c.Header("X-Debug", "123")
+		c.SetCookie("session", "secret", 3600, "/", "", false, true)
+		c.String(http.StatusOK, "headers")
+	})
+	origins := []string{"https://a.example", "https://b.example"}
+	for i := range origins {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/headers", nil)
+		req.Header.Set("Origin", origins[i])
+		app.ServeHTTP(w, req)
+		if w.Header().Get("Cache-Control") != "public" {
+			t.Errorf("Cache-Control is not replayed on %v request", i)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != origins[i] {
+			t.Errorf("wrong Access-Control-Allow-Origin %s on %v request",
+				w.Header().Get("Access-Control-Allow-Origin"), i)
+		}
+		if w.Header().Get("X-Request-Id") != strconv.Itoa(i+1) {
+			t.Errorf("wrong X-Request-Id %s on %v request", w.Header().Get("X-Request-Id"), i)
+		}
+	}
+	data, found := cache.items["/headers"]
+	if !found {
+		t.Error("data not found!")
+		return
+	}
+	if data.Headers.Get("Set-Cookie") != "" {
+		t.Error("Set-Cookie is saved")
+	}
+	if data.Headers.Get("X-Debug") != "" {
+		t.Error("denied header is saved")
+	}
+	if data.Headers.Get("X-Request-Id") != "" || data.Headers.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("headers set before handler are saved")
+	}
+	if data.Headers.Get("Cache-Control") != "public" {
+		t.Error("Cache-Control is not saved")
+	}
+	if data.Headers.Get("Vary") != "Accept-Encoding" {
+		t.Error("header changed by handler is not saved")
+	}
+}
+
+func TestETag(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithETag(),
+	))
+	app.GET("/etag", func(c *gin.Context) {
+		c.String(http.StatusOK, "entity")
+	})
+	expected := ETag([]byte("entity"))
+	// miss
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", expected)
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("wrong status on miss %v", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("body is sent with 304 on miss")
+	}
+	if w.Header().Get("ETag") != expected {
+		t.Errorf("wrong etag on miss %s", w.Header().Get("ETag"))
+	}
+	if cache.items["/etag"].ETag != expected {
+		t.Error("etag is not saved")
+	}
+	// hit
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", `"other", W/`+expected)
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("wrong status on hit %v", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("body is sent with 304 on hit")
+	}
+	// hit without matching etag
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("wrong status on hit %v", w.Code)
+	}
+	if w.Body.String() != "entity" {
+		t.Errorf("wrong body on hit %s", w.Body.String())
+	}
+	if w.Header().Get("ETag") != expected {
+		t.Errorf("wrong etag on hit %s", w.Header().Get("ETag"))
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(New(cache, CacheByPath(time.Minute)))
+	app.GET("/modified", func(c *gin.Context) {
+		c.String(http.StatusOK, "modified")
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/modified", nil))
+	lastModified := w.Header().Get("Last-Modified")
+	if !strings.HasSuffix(lastModified, " GMT") {
+		t.Errorf("Last-Modified is not IMF-fixdate: %s", lastModified)
+	}
+	if _, err := http.ParseTime(w.Header().Get("Expires")); err != nil {
+		t.Errorf("%s : while parsing Expires", err)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/modified", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("wrong status %v", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("body is sent with 304")
+	}
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/modified", nil)
+	req.Header.Set("If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("wrong status %v", w.Code)
+	}
+	if w.Body.String() != "modified" {
+		t.Errorf("wrong body %s", w.Body.String())
+	}
+	// dates later than current time are ignored
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/modified", nil)
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("wrong status for date in future %v", w.Code)
+	}
+	// responses, that are not cached, have no Last-Modified, so If-Modified-Since is not evaluated
+	buffered := gin.New()
+	buffered.Use(NewWithOptions(cache, WithBufferedResponse()))
+	buffered.GET("/private", func(c *gin.Context) {
+		c.Header("Cache-Control", "private")
+		c.String(http.StatusOK, "private")
+	})
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/private", nil)
+	req.Header.Set("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat))
+	buffered.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "private" {
+		t.Errorf("wrong response for not cacheable one %v %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Last-Modified") != "" {
+		t.Error("Last-Modified is set for not cacheable response")
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	var rendered int32
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithCoalescing(time.Second),
+	))
+	app.GET("/slow", func(c *gin.Context) {
+		atomic.AddInt32(&rendered, 1)
+		time.Sleep(100 * time.Millisecond)
+		c.String(http.StatusOK, "slow")
+	})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			if w.Body.String() != "slow" {
+				t.Errorf("wrong body %s", w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&rendered) != 1 {
+		t.Errorf("handler is called %v times", rendered)
+	}
+}
+
+// lockingCacher imitates cache shared between few processes, that supports locking
+type lockingCacher struct {
+	testCacher
+	locks map[string]string
+}
+
+func (l *lockingCacher) Lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error) {
+	l.testCacher.Lock()
+	defer l.testCacher.Unlock()
+	_, found := l.locks[key]
+	if found {
+		return "", false, nil
+	}
+	token = fmt.Sprintf("%v", time.Now().UnixNano())
+	l.locks[key] = token
+	return token, true, nil
+}
+
+func (l *lockingCacher) Unlock(ctx context.Context, key, token string) (err error) {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	l.testCacher.Lock()
+	defer l.testCacher.Unlock()
+	if l.locks[key] == token {
+		delete(l.locks, key)
+	}
+	return nil
+}
+
+func TestDistributedLock(t *testing.T) {
+	var rendered int32
+	cache := &lockingCacher{
+		testCacher: testCacher{items: make(map[string]Data)},
+		locks:      make(map[string]string),
+	}
+	// every engine imitates separate process
+	apps := make([]*gin.Engine, 3)
+	for i := range apps {
+		apps[i] = gin.New()
+		apps[i].Use(NewWithOptions(cache,
+			WithKeyExtractor(CacheByPath(time.Minute)),
+			WithDistributedLock(time.Second, time.Second, 10*time.Millisecond),
+		))
+		apps[i].GET("/locked", func(c *gin.Context) {
+			atomic.AddInt32(&rendered, 1)
+			time.Sleep(100 * time.Millisecond)
+			c.String(http.StatusOK, "locked")
+		})
+	}
+	wg := sync.WaitGroup{}
+	for i := range apps {
+		wg.Add(1)
+		go func(app *gin.Engine) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/locked", nil))
+			if w.Body.String() != "locked" {
+				t.Errorf("wrong body %s", w.Body.String())
+			}
+		}(apps[i])
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&rendered) != 1 {
+		t.Errorf("handler is called %v times", rendered)
+	}
+	if len(cache.locks) != 0 {
+		t.Error("lock is not released")
+	}
+}
+
+func TestDistributedLockDefaults(t *testing.T) {
+	cache := &lockingCacher{
+		testCacher: testCacher{items: make(map[string]Data)},
+		locks:      map[string]string{"/held": "other process"},
+	}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithDistributedLock(100*time.Millisecond, 0, 0),
+	))
+	ctx, cancel := context.WithCancel(context.Background())
+	app.GET("/:path", func(c *gin.Context) {
+		// client disconnects, while response is rendered
+		cancel()
+		c.String(http.StatusOK, "rendered")
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/held", nil))
+	if w.Body.String() != "rendered" {
+		t.Errorf("wrong body %s after lock timeout", w.Body.String())
+	}
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/free", nil).WithContext(ctx))
+	if _, found := cache.locks["/free"]; found {
+		t.Error("lock is not released after client disconnected")
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var rendered int32
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(100*time.Millisecond)),
+		WithStaleWhileRevalidate(time.Minute, app),
+	))
+	app.GET("/stale", func(c *gin.Context) {
+		version := atomic.AddInt32(&rendered, 1)
+		c.String(http.StatusOK, "version %v", version)
+	})
+	get := func() string {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stale", nil))
+		return w.Body.String()
+	}
+	if body := get(); body != "version 1" {
+		t.Errorf("wrong body %s", body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if body := get(); body != "version 1" {
+		t.Errorf("stale response is not served: %s", body)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if body := get(); body != "version 2" {
+		t.Errorf("response is not revalidated: %s", body)
+	}
+	if atomic.LoadInt32(&rendered) != 2 {
+		t.Errorf("handler is called %v times", rendered)
+	}
+}
+
+func TestRevalidationTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { revalidationTimeout = timeout }(revalidationTimeout)
+	revalidationTimeout = 50 * time.Millisecond
+	var rendered int32
+	hang := make(chan struct{})
+	defer close(hang)
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(50*time.Millisecond)),
+		WithStaleWhileRevalidate(time.Minute, app),
+	))
+	app.GET("/hung", func(c *gin.Context) {
+		if atomic.AddInt32(&rendered, 1) == 2 {
+			// first revalidation hangs
+			<-hang
+		}
+		c.String(http.StatusOK, "rendered")
+	})
+	get := func() {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hung", nil))
+	}
+	get()
+	time.Sleep(100 * time.Millisecond)
+	get()
+	time.Sleep(100 * time.Millisecond)
+	get()
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&rendered) != 3 {
+		t.Errorf("key is not revalidated after hung revalidation timed out, handler is called %v times", rendered)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("stale-while-revalidate is enabled without engine")
+		}
+	}()
+	WithStaleWhileRevalidate(time.Minute, nil)
+}
+
+func TestStaleIfError(t *testing.T) {
+	var rendered int32
+	var reported []Phase
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	}))
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(100*time.Millisecond)),
+		WithStaleIfError(time.Minute),
+		WithDiagnosticHeaders(DefaultDiagnosticHeaders),
+		OnError(func(c *gin.Context, phase Phase, err error) {
+			reported = append(reported, phase)
+		}),
+	))
+	app.GET("/flaky", func(c *gin.Context) {
+		switch atomic.AddInt32(&rendered, 1) {
+		case 1:
+			c.String(http.StatusOK, "good")
+		case 2:
+			c.Header("X-Partial", "true")
+			c.String(http.StatusInternalServerError, "bad")
+		case 3:
+			panic("handler failed")
+		default:
+			c.String(http.StatusOK, "better")
+		}
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+		return w
+	}
+	w := get()
+	if w.Body.String() != "good" {
+		t.Errorf("wrong body %s", w.Body.String())
+	}
+	time.Sleep(150 * time.Millisecond)
+	for _, failure := range []string{"error", "panic"} {
+		w = get()
+		if w.Code != http.StatusOK {
+			t.Errorf("wrong status on %s: %v", failure, w.Code)
+		}
+		if w.Body.String() != "good" {
+			t.Errorf("stale response is not served on %s: %s", failure, w.Body.String())
+		}
+		if w.Header().Get("X-Cache") != "STALE" {
+			t.Errorf("X-Cache is not set on %s", failure)
+		}
+		if w.Header().Get("Warning") != `111 - "Revalidation Failed"` {
+			t.Errorf("wrong Warning %s on %s", w.Header().Get("Warning"), failure)
+		}
+		if w.Header().Get("X-Partial") != "" {
+			t.Errorf("headers of failed response are sent on %s", failure)
+		}
+	}
+	if len(reported) != 1 || reported[0] != PhaseHandler {
+		t.Errorf("handler panic is not reported: %v", reported)
+	}
+	w = get()
+	if w.Body.String() != "better" {
+		t.Errorf("wrong body %s", w.Body.String())
+	}
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Error("X-Cache is not MISS for fresh response")
+	}
+}
+
+func TestBufferedResponse(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithStatusTTL(map[int]time.Duration{http.StatusOK: time.Hour}),
+		WithBufferedResponse(),
+		WithDiagnosticHeaders(DefaultDiagnosticHeaders),
+	))
+	app.GET("/buffered", func(c *gin.Context) {
+		c.String(http.StatusOK, "buffered")
+	})
+	app.GET("/teapot", func(c *gin.Context) {
+		c.String(http.StatusTeapot, "teapot")
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/buffered", nil))
+	if w.Body.String() != "buffered" {
+		t.Errorf("wrong body %s", w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "8" {
+		t.Errorf("wrong Content-Length %s", w.Header().Get("Content-Length"))
+	}
+	if w.Header().Get("Age") != "0" {
+		t.Errorf("wrong Age %s", w.Header().Get("Age"))
+	}
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Errorf("%s : while parsing Last-Modified", err)
+	}
+	expires, err := http.ParseTime(w.Header().Get("Expires"))
+	if err != nil {
+		t.Errorf("%s : while parsing Expires", err)
+	}
+	if expires.Sub(lastModified) != time.Hour {
+		t.Errorf("Expires does not respect status ttl: %s", expires.Sub(lastModified))
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/teapot", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("wrong status %v", w.Code)
+	}
+	if w.Header().Get("Expires") != "" {
+		t.Error("Expires is set for response, that is not cached")
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(New(cache, CacheByPath(time.Minute)))
+	directives := map[string]string{
+		"/no-store": "no-store",
+		"/private":  "private, max-age=60",
+		"/zero":     "public, max-age=0",
+		"/max-age":  "public, max-age=3600",
+		"/s-maxage": "max-age=60, s-maxage=600",
+	}
+	for target, directive := range directives {
+		value := directive
+		app.GET(target, func(c *gin.Context) {
+			c.Header("Cache-Control", value)
+			c.String(http.StatusOK, value)
+		})
+	}
+	app.GET("/expires", func(c *gin.Context) {
+		c.Header("Expires", time.Now().Add(2*time.Hour).UTC().Format(http.TimeFormat))
+		c.String(http.StatusOK, "expires")
+	})
+	for target := range directives {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/expires", nil))
+	for _, target := range []string{"/no-store", "/private", "/zero"} {
+		if _, found := cache.items[target]; found {
+			t.Errorf("%s is cached", target)
+		}
+	}
+	expected := map[string]time.Duration{
+		"/max-age":  time.Hour,
+		"/s-maxage": 10 * time.Minute,
+		"/expires":  2 * time.Hour,
+	}
+	for target, ttl := range expected {
+		data, found := cache.items[target]
+		if !found {
+			t.Errorf("%s is not cached", target)
+			continue
+		}
+		if diff := data.ExpiresAt.Sub(data.CreatedAt) - ttl; diff > time.Second || diff < -time.Second {
+			t.Errorf("wrong ttl for %s: %s", target, data.ExpiresAt.Sub(data.CreatedAt))
+		}
+	}
+}
+
+func TestBypassAndRefresh(t *testing.T) {
+	var rendered int32
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithNoCacheBypass(RequireHeader("X-Admin", "secret")),
+		WithRefresh(RequireHeader("X-Refresh", "secret")),
+	))
+	app.GET("/bypass", func(c *gin.Context) {
+		c.String(http.StatusOK, "version %v", atomic.AddInt32(&rendered, 1))
+	})
+	get := func(headers map[string]string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/bypass", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		app.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	if body := get(nil); body != "version 1" {
+		t.Errorf("wrong body %s", body)
+	}
+	if body := get(map[string]string{"Cache-Control": "no-cache"}); body != "version 1" {
+		t.Errorf("unauthorized bypass: %s", body)
+	}
+	if body := get(map[string]string{"Pragma": "no-cache", "X-Admin": "secret"}); body != "version 2" {
+		t.Errorf("cache is not bypassed: %s", body)
+	}
+	if body := get(nil); body != "version 1" {
+		t.Errorf("bypassed response is saved: %s", body)
+	}
+	if body := get(map[string]string{"X-Refresh": "wrong"}); body != "version 1" {
+		t.Errorf("unauthorized refresh: %s", body)
+	}
+	if body := get(map[string]string{"X-Refresh": "secret"}); body != "version 3" {
+		t.Errorf("cache is not refreshed: %s", body)
+	}
+	if body := get(nil); body != "version 3" {
+		t.Errorf("refreshed response is not saved: %s", body)
+	}
+}
+
+func TestDiagnosticHeaders(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithNoCacheBypass(nil),
+		WithDiagnosticHeaders(DiagnosticHeaders{Status: "X-Cache-Status", Age: "Age", Timing: "Server-Timing"}),
+	))
+	app.GET("/diagnostics", func(c *gin.Context) {
+		c.String(http.StatusOK, "diagnostics")
+	})
+	expected := []string{"MISS", "HIT", "BYPASS"}
+	for i := range expected {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/diagnostics", nil)
+		if expected[i] == "BYPASS" {
+			req.Header.Set("Cache-Control", "no-cache")
+		}
+		app.ServeHTTP(w, req)
+		if w.Header().Get("X-Cache-Status") != expected[i] {
+			t.Errorf("wrong status %s instead of %s", w.Header().Get("X-Cache-Status"), expected[i])
+		}
+		if expected[i] == "HIT" && w.Header().Get("Age") != "0" {
+			t.Errorf("wrong Age %s", w.Header().Get("Age"))
+		}
+		if expected[i] != "BYPASS" && !strings.HasPrefix(w.Header().Get("Server-Timing"), "cache;") {
+			t.Errorf("wrong Server-Timing %s", w.Header().Get("Server-Timing"))
+		}
+	}
+	if cache.items["/diagnostics"].Headers.Get("X-Cache-Status") != "" {
+		t.Error("diagnostic header is saved")
+	}
+
+	// diagnostic headers are disabled by default
+	app = gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+	))
+	app.GET("/diagnostics", func(c *gin.Context) {
+		c.String(http.StatusOK, "diagnostics")
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/diagnostics", nil))
+	for _, name := range []string{"X-Cache", "Age", "Server-Timing"} {
+		if w.Header().Get(name) != "" {
+			t.Errorf("%s is set", name)
+		}
+	}
+
+	for _, headers := range []DiagnosticHeaders{{}, {Age: "X-Cache-Age"}} {
+		app = gin.New()
+		app.Use(NewWithOptions(&testCacher{items: make(map[string]Data)},
+			WithKeyExtractor(CacheByPath(time.Minute)),
+			WithBufferedResponse(),
+			WithDiagnosticHeaders(headers),
+		))
+		app.GET("/diagnostics", func(c *gin.Context) {
+			c.String(http.StatusOK, "diagnostics")
+		})
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/diagnostics", nil))
+		if w.Header().Get("Age") != "" {
+			t.Errorf("Age is set on buffered miss with diagnostic headers %+v", headers)
+		}
+		if headers.Age != "" && w.Header().Get(headers.Age) != "0" {
+			t.Errorf("wrong %s %s on buffered miss", headers.Age, w.Header().Get(headers.Age))
+		}
+	}
+}
+
+func TestHandlerDirectives(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(New(cache, CacheByPath(time.Minute)))
+	app.GET("/skip", func(c *gin.Context) {
+		Skip(c)
+		c.String(http.StatusOK, "skip")
+	})
+	app.GET("/ttl", func(c *gin.Context) {
+		SetTTL(c, 10*time.Minute)
+		AddTags(c, "product:1")
+		AddTags(c, "category:2", "category:3")
+		c.String(http.StatusOK, "ttl")
+	})
+	for _, target := range []string{"/skip", "/ttl"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	if _, found := cache.items["/skip"]; found {
+		t.Error("skipped response is saved")
+	}
+	data, found := cache.items["/ttl"]
+	if !found {
+		t.Error("response is not saved")
+		return
+	}
+	if data.ExpiresAt.Sub(data.CreatedAt) != 10*time.Minute {
+		t.Errorf("wrong ttl %s", data.ExpiresAt.Sub(data.CreatedAt))
+	}
+	if strings.Join(data.Tags, " ") != "product:1 category:2 category:3" {
+		t.Errorf("wrong tags %v", data.Tags)
+	}
+}
+
+func TestShouldCache(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(New(cache, CacheByPath(time.Minute)))
+	app.GET("/error", func(c *gin.Context) {
+		_ = c.Error(fmt.Errorf("something went wrong"))
+		c.JSON(http.StatusOK, gin.H{"error": "something went wrong"})
+	})
+	app.GET("/abort", func(c *gin.Context) {
+		_ = c.AbortWithError(http.StatusOK, fmt.Errorf("something went wrong"))
+	})
+	for _, target := range []string{"/error", "/abort"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		if _, found := cache.items[target]; found {
+			t.Errorf("response with errors is saved for %s", target)
+		}
+	}
+
+	app = gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithShouldCache(func(c *gin.Context) bool {
+			return c.Writer.Header().Get("X-Personal") == ""
+		}),
+	))
+	app.GET("/error", func(c *gin.Context) {
+		_ = c.Error(fmt.Errorf("something went wrong"))
+		c.String(http.StatusOK, "error")
+	})
+	app.GET("/personal", func(c *gin.Context) {
+		c.Header("X-Personal", "true")
+		c.String(http.StatusOK, "personal")
+	})
+	for _, target := range []string{"/error", "/personal"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	if _, found := cache.items["/error"]; !found {
+		t.Error("response is not saved with custom predicate")
+	}
+	if _, found := cache.items["/personal"]; found {
+		t.Error("response is saved despite custom predicate")
+	}
+}
+
+func TestSurrogateKey(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(New(cache, CacheByPath(time.Minute)))
+	app.GET("/tagged", func(c *gin.Context) {
+		AddTags(c, "product:1")
+		c.Header("Surrogate-Key", "product:1 category:2")
+		c.String(http.StatusOK, "tagged")
+	})
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tagged", nil))
+	if strings.Join(cache.items["/tagged"].Tags, " ") != "product:1 category:2" {
+		t.Errorf("wrong tags %v", cache.items["/tagged"].Tags)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"/catalog/*", "/catalog/1/reviews", true},
+		{"/catalog/*", "/catalogue", false},
+		{"/posts/?", "/posts/1", true},
+		{"/posts/?", "/posts/10", false},
+		{"/posts/[0-9]", "/posts/5", true},
+		{"/posts/[^0-9]", "/posts/5", false},
+		{`/what\?`, "/what?", true},
+		{`/what\?`, "/whats", false},
+		{"/a.b", "/axb", false},
+	}
+	for i := range cases {
+		if MatchGlob(cases[i].pattern, cases[i].key) != cases[i].match {
+			t.Errorf("wrong match of %s against %s", cases[i].key, cases[i].pattern)
+		}
+	}
+}
+
+// generationCacher imitates cache, that supports namespace generations
+type generationCacher struct {
+	testCacher
+	generations map[string]uint64
+}
+
+func (g *generationCacher) Generation(ctx context.Context, namespace string) (generation uint64, err error) {
+	g.RLock()
+	defer g.RUnlock()
+	return g.generations[namespace], nil
+}
+
+func (g *generationCacher) BumpGeneration(ctx context.Context, namespace string) (generation uint64, err error) {
+	g.testCacher.Lock()
+	defer g.testCacher.Unlock()
+	g.generations[namespace]++
+	return g.generations[namespace], nil
+}
+
+func TestNamespace(t *testing.T) {
+	var rendered int32
+	cache := &generationCacher{
+		testCacher:  testCacher{items: make(map[string]Data)},
+		generations: make(map[string]uint64),
+	}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		WithNamespace("catalog"),
+	))
+	app.GET("/catalog", func(c *gin.Context) {
+		c.String(http.StatusOK, "version %v", atomic.AddInt32(&rendered, 1))
+	})
+	get := func() string {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/catalog", nil))
+		return w.Body.String()
+	}
+	if body := get(); body != "version 1" {
+		t.Errorf("wrong body %s", body)
+	}
+	if _, found := cache.items[NamespacedKey("catalog", 0, "/catalog")]; !found {
+		t.Error("response is not saved in namespace")
+	}
+	if body := get(); body != "version 1" {
+		t.Errorf("response is not cached: %s", body)
+	}
+	_, err := cache.BumpGeneration(context.Background(), "catalog")
+	if err != nil {
+		t.Error(err)
+	}
+	if body := get(); body != "version 2" {
+		t.Errorf("namespace is not invalidated: %s", body)
+	}
+	if _, found := cache.items[NamespacedKey("catalog", 1, "/catalog")]; !found {
+		t.Error("response is not saved in new generation of namespace")
+	}
+}
+
+func TestInvalidationRules(t *testing.T) {
+	var failures []Phase
+	cache := &testCacher{items: make(map[string]Data)}
+	app := gin.New()
+	app.Use(NewWithOptions(cache,
+		WithKeyExtractor(CacheByPath(time.Minute)),
+		OnError(func(c *gin.Context, phase Phase, err error) {
+			t.Errorf("unexpected error %s in phase %s", err, phase)
+		}),
+		WithInvalidationRules(InvalidationRule{
+			Method:       http.MethodDelete,
+			Route:        "/posts/:id",
+			Invalidation: Invalidation{Keys: []string{"/posts", "/posts/:id"}},
+		}),
+	))
+	app.GET("/posts", func(c *gin.Context) {
+		c.String(http.StatusOK, "posts")
+	})
+	app.GET("/posts/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "post %s", c.Param("id"))
+	})
+	app.DELETE("/posts/:id", func(c *gin.Context) {
+		if c.Param("id") == "forbidden" {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	serve := func(method, path string) {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+	for _, path := range []string{"/posts", "/posts/1", "/posts/2", "/posts/forbidden"} {
+		serve(http.MethodGet, path)
+	}
+	if len(cache.items) != 4 {
+		t.Fatalf("wrong number of responses cached %v", len(cache.items))
+	}
+	serve(http.MethodDelete, "/posts/forbidden")
+	if len(cache.items) != 4 {
+		t.Errorf("responses are invalidated after failed request")
+	}
+	serve(http.MethodDelete, "/posts/1")
+	for key, shouldBeFound := range map[string]bool{
+		"/posts":           false,
+		"/posts/1":         false,
+		"/posts/2":         true,
+		"/posts/forbidden": true,
+	} {
+		if _, found := cache.items[key]; found != shouldBeFound {
+			t.Errorf("response with key %s is found %v instead of %v", key, found, shouldBeFound)
+		}
+	}
+	// rules, that caching backend cannot apply, are rejected, when middleware is created
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("rule with tags is accepted for caching backend without TagInvalidator")
+			}
+		}()
+		NewWithOptions(cache, WithInvalidationRules(InvalidationRule{
+			Method:       http.MethodPut,
+			Route:        "/posts/:id",
+			Invalidation: Invalidation{Tags: []string{"post::id"}},
+		}))
+	}()
+	// response is already written, when rules are applied, so their errors are only reported
+	failing := gin.New()
+	failing.Use(NewWithOptions(failingCacher{},
+		OnError(func(c *gin.Context, phase Phase, err error) {
+			failures = append(failures, phase)
+		}),
+		WithInvalidationRules(InvalidationRule{
+			Method:       http.MethodPost,
+			Route:        "/posts",
+			Invalidation: Invalidation{Keys: []string{"/posts"}},
+		}),
+	))
+	failing.POST("/posts", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	w := httptest.NewRecorder()
+	failing.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/posts", nil))
+	if w.Code != http.StatusCreated {
+		t.Errorf("wrong status %v", w.Code)
+	}
+	if len(failures) != 1 || failures[0] != PhaseInvalidate {
+		t.Errorf("invalidation error is not reported: %v", failures)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	cache := &testCacher{items: make(map[string]Data)}
+	cache.items["/posts/1"] = Data{Body: []byte("post 1")}
+	cache.items["/posts?page=2"] = Data{Body: []byte("posts")}
+	app := gin.New()
+	AdminRoutes(app.Group("/cache"), cache, RequireHeader("X-Admin", "secret"))
+	request := func(method, path, header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("X-Admin", "secret")
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(MethodPurge, "/cache/posts/1", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unauthorized request is not rejected: %v", w.Code)
+	}
+	for _, test := range []struct {
+		method, path, header, value string
+		status                      int
+		body                        string
+	}{
+		{MethodPurge, "/cache/posts/1", "", "", http.StatusOK, `{"removed":1}`},
+		{MethodPurge, "/cache/posts/1", "", "", http.StatusOK, `{"removed":0}`},
+		{MethodPurge, "/cache/posts?page=2", "", "", http.StatusOK, `{"removed":1}`},
+		{MethodPurge, "/cache/posts/*", "", "", http.StatusNotImplemented, ""},
+		{MethodPurge, "/cache/", PurgeTagsHeader, "post:1", http.StatusNotImplemented, ""},
+		{MethodBan, "/cache/", "", "", http.StatusBadRequest, ""},
+		{MethodBan, "/cache/", BanHeader, "^/posts/[0-9]+$", http.StatusNotImplemented, ""},
+	} {
+		w = request(test.method, test.path, test.header, test.value)
+		if w.Code != test.status {
+			t.Errorf("wrong status %v for %s %s", w.Code, test.method, test.path)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("wrong body %s for %s %s", w.Body.String(), test.method, test.path)
+		}
+	}
+	if len(cache.items) != 0 {
+		t.Errorf("responses are not purged: %v", cache.items)
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	secret := []byte("secret")
+	cache := &testCacher{items: make(map[string]Data)}
+	cache.items["/posts/1"] = Data{Body: []byte("post 1")}
+	cache.items["/posts/2"] = Data{Body: []byte("post 2")}
+	app := gin.New()
+	app.POST("/webhook", WebhookHandler(cache, secret, time.Minute))
+	send := func(timestamp time.Time, payload, signature string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+		r.Header.Set(WebhookTimestampHeader, fmt.Sprint(timestamp.Unix()))
+		r.Header.Set(WebhookSignatureHeader, signature)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+	payload := `{"keys":["/posts/1"]}`
+	now := time.Now()
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"wrong secret": send(now, payload, SignWebhook([]byte("wrong"), now, []byte(payload))),
+		"tampered":     send(now, `{"keys":["/posts/2"]}`, SignWebhook(secret, now, []byte(payload))),
+		"replayed": send(now.Add(-time.Hour), payload,
+			SignWebhook(secret, now.Add(-time.Hour), []byte(payload))),
+	} {
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s webhook is not rejected: %v", name, w.Code)
+		}
+	}
+	if len(cache.items) != 2 {
+		t.Errorf("responses are invalidated by rejected webhooks")
+	}
+	w := send(now, payload, "sha256="+SignWebhook(secret, now, []byte(payload)))
+	if w.Code != http.StatusOK {
+		t.Errorf("wrong status %v", w.Code)
+	}
+	if w.Body.String() != `{"removed":1}` {
+		t.Errorf("wrong body %s", w.Body.String())
+	}
+	if _, found := cache.items["/posts/1"]; found {
+		t.Error("response is not invalidated")
+	}
+	payload = `{"tags":["post:2"]}`
+	w = send(now, payload, SignWebhook(secret, now, []byte(payload)))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("wrong status %v for unsupported tag invalidation", w.Code)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("webhook handler with empty secret is created")
+		}
+	}()
+	WebhookHandler(cache, nil, time.Minute)
+}
+
+// prefixCacher is test cache, that can delete items by key prefix
+type prefixCacher struct {
+	testCacher
+}
+
+func (p *prefixCacher) DeletePrefix(ctx context.Context, prefix string) (removed int, err error) {
+	p.testCacher.Lock()
+	defer p.testCacher.Unlock()
+	for key := range p.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(p.items, key)
+			removed++
+		}
+	}
+	return
+}
+
+func (p *prefixCacher) DeleteMatching(ctx context.Context, pattern string) (removed int, err error) {
+	return 0, ErrUnsupported
+}
+
+func TestAdminRoutesFlush(t *testing.T) {
+	cache := &prefixCacher{testCacher: testCacher{items: make(map[string]Data)}}
+	for _, key := range []string{NamespacedKey("catalog", 0, "/a"), "custom-key", "/b", "/posts/1"} {
+		cache.items[key] = Data{Body: []byte(key)}
+	}
+	app := gin.New()
+	AdminRoutes(app, cache, func(c *gin.Context) bool { return true })
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(MethodPurge, "/posts/*", nil))
+	if w.Body.String() != `{"removed":1}` {
+		t.Errorf("wrong body %s for prefix purge", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(MethodPurge, "/*", nil))
+	if w.Body.String() != `{"removed":3}` {
+		t.Errorf("wrong body %s for flush", w.Body.String())
+	}
+	if len(cache.items) != 0 {
+		t.Errorf("cache is not flushed: %v", cache.items)
+	}
+}
@@ -0,0 +1,91 @@
+package gincache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// WebhookSignatureHeader is request header with hex encoded HMAC-SHA256 signature of webhook,
+	// optionally prefixed with `sha256=`
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// WebhookTimestampHeader is request header with unix time in seconds, when webhook was sent
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// DefaultWebhookTolerance is maximum difference between webhook timestamp and current time
+	DefaultWebhookTolerance = 5 * time.Minute
+	// maxWebhookSize is maximum size of webhook payload in bytes
+	maxWebhookSize = 1 << 20
+)
+
+// SignWebhook returns hex encoded HMAC-SHA256 signature of webhook payload sent at timestamp provided.
+// Signed message is timestamp in unix seconds, dot and payload, like `1700000000.{"keys":["/posts/1"]}`.
+func SignWebhook(secret []byte, timestamp time.Time, payload []byte) string {
+	return hex.EncodeToString(signWebhook(secret, timestamp, payload))
+}
+
+// signWebhook returns raw HMAC-SHA256 signature of webhook
+func signWebhook(secret []byte, timestamp time.Time, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// WebhookHandler returns handler, that receives webhooks with JSON encoded Invalidation as payload, like
+//
+//	{"keys":["/posts/1"],"prefixes":["/posts/1/"],"tags":["post:1"]}
+//
+// and invalidates responses cached by caching backend provided. Webhook is accepted, only if it is signed
+// with secret by SignWebhook, and its timestamp differs from current time no more than tolerance, so
+// intercepted webhooks cannot be replayed later. Zero tolerance means DefaultWebhookTolerance.
+// Response is JSON object with number of responses removed, like `{"removed":2}`, or with error.
+// It panics, if secret is empty, because anyone could sign webhooks with it.
+func WebhookHandler(cache Cache, secret []byte, tolerance time.Duration) gin.HandlerFunc {
+	if len(secret) == 0 {
+		panic("gincache: webhook secret is empty")
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+	return func(c *gin.Context) {
+		unix, err := strconv.ParseInt(c.GetHeader(WebhookTimestampHeader), 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid webhook timestamp"})
+			return
+		}
+		timestamp := time.Unix(unix, 0)
+		age := time.Since(timestamp)
+		if age > tolerance || age < -tolerance {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "webhook timestamp is out of tolerance"})
+			return
+		}
+		payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookSize))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		signature, err := hex.DecodeString(strings.TrimPrefix(c.GetHeader(WebhookSignatureHeader), "sha256="))
+		if err != nil || !hmac.Equal(signature, signWebhook(secret, timestamp, payload)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid webhook signature"})
+			return
+		}
+		var invalidation Invalidation
+		err = json.Unmarshal(payload, &invalidation)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		removed, err := Invalidate(c.Request.Context(), cache, invalidation)
+		respondInvalidation(c, removed, err)
+	}
+}
@@ -0,0 +1,199 @@
+package gincache
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recorder is response writer, that records response body to be saved in cache
+type recorder interface {
+	gin.ResponseWriter
+	// recorded returns response body and reports, if it was recorded completely
+	recorded() (body []byte, complete bool)
+	// initialHeader returns copy of response headers made before handler was executed
+	initialHeader() http.Header
+}
+
+// Good read
+// https://github.com/gin-gonic/gin/issues/1363#issuecomment-577722498
+
+// sniffer writes response to client and records it at the same time
+type sniffer struct {
+	gin.ResponseWriter
+	// header is copy of response headers made before handler is executed
+	header http.Header
+	body   *bytes.Buffer
+	// limit is maximum body size to be sniffed, zero means no limit
+	limit    int
+	overflow bool
+}
+
+func (s *sniffer) sniff(b []byte) {
+	if s.overflow {
+		return
+	}
+	if s.limit > 0 && s.body.Len()+len(b) > s.limit {
+		s.overflow = true
+		s.body.Reset()
+		return
+	}
+	s.body.Write(b)
+}
+
+func (s *sniffer) Write(b []byte) (int, error) {
+	s.sniff(b)
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *sniffer) WriteString(payload string) (n int, err error) {
+	s.sniff([]byte(payload))
+	return s.ResponseWriter.WriteString(payload)
+}
+
+func (s *sniffer) recorded() ([]byte, bool) {
+	return s.body.Bytes(), !s.overflow
+}
+
+func (s *sniffer) initialHeader() http.Header {
+	return s.header
+}
+
+// bufferedWriter holds response status and body in memory, so middleware can change headers or discard
+// response after handler is executed. If body exceeds limit, or handler flushes response, it is committed
+// to client and written through afterwards, and such response cannot be cached.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	// header is copy of response headers made before handler is executed, so they can be restored,
+	// if response is discarded
+	header      http.Header
+	body        *bytes.Buffer
+	status      int
+	wroteHeader bool
+	committed   bool
+	// limit is maximum body size to be buffered, zero means no limit
+	limit int
+}
+
+func newBufferedWriter(w gin.ResponseWriter, limit int) *bufferedWriter {
+	return &bufferedWriter{
+		ResponseWriter: w,
+		header:         w.Header().Clone(),
+		body:           &bytes.Buffer{},
+		status:         w.Status(),
+		limit:          limit,
+	}
+}
+
+func (b *bufferedWriter) WriteHeader(code int) {
+	if b.committed {
+		b.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 && !b.wroteHeader {
+		b.status = code
+	}
+}
+
+func (b *bufferedWriter) WriteHeaderNow() {
+	if b.committed {
+		b.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	b.wroteHeader = true
+}
+
+func (b *bufferedWriter) Write(data []byte) (int, error) {
+	if !b.committed && b.limit > 0 && b.body.Len()+len(data) > b.limit {
+		b.commit()
+	}
+	if b.committed {
+		return b.ResponseWriter.Write(data)
+	}
+	b.wroteHeader = true
+	return b.body.Write(data)
+}
+
+func (b *bufferedWriter) WriteString(payload string) (int, error) {
+	return b.Write([]byte(payload))
+}
+
+func (b *bufferedWriter) Status() int {
+	if b.committed {
+		return b.ResponseWriter.Status()
+	}
+	return b.status
+}
+
+func (b *bufferedWriter) Size() int {
+	if b.committed {
+		return b.ResponseWriter.Size()
+	}
+	if !b.wroteHeader {
+		return -1
+	}
+	return b.body.Len()
+}
+
+func (b *bufferedWriter) Written() bool {
+	if b.committed {
+		return b.ResponseWriter.Written()
+	}
+	return b.wroteHeader
+}
+
+func (b *bufferedWriter) Flush() {
+	b.commit()
+	b.ResponseWriter.Flush()
+}
+
+func (b *bufferedWriter) recorded() ([]byte, bool) {
+	return b.body.Bytes(), !b.committed
+}
+
+func (b *bufferedWriter) initialHeader() http.Header {
+	return b.header
+}
+
+// commit writes buffered status and body to client
+func (b *bufferedWriter) commit() {
+	if b.committed {
+		return
+	}
+	b.committed = true
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.WriteHeaderNow()
+	if b.body.Len() > 0 {
+		b.ResponseWriter.Write(b.body.Bytes())
+	}
+}
+
+// discard drops buffered body and writes status provided to client
+func (b *bufferedWriter) discard(status int) {
+	if b.committed {
+		return
+	}
+	b.committed = true
+	b.ResponseWriter.WriteHeader(status)
+	b.ResponseWriter.WriteHeaderNow()
+}
+
+// reset drops buffered response and restores headers to state before handler was executed,
+// so other response can be written instead of it. It reports false, if response is already committed.
+func (b *bufferedWriter) reset() bool {
+	if b.committed {
+		return false
+	}
+	header := b.ResponseWriter.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range b.header {
+		header[name] = values
+	}
+	b.body.Reset()
+	b.status = http.StatusOK
+	b.wroteHeader = false
+	return true
+}